@@ -0,0 +1,56 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrAuditFingerprint = errors.New("oppobloom: fingerprint filters cannot keep an audit log")
+
+// auditLog writes the ids a filter made with WithAuditLog sees to w, one at a
+// time.
+type auditLog struct {
+	mu     sync.Mutex // serializes writes to w
+	w      io.Writer
+	buf    []byte
+	failed atomic.Uint64
+	err    atomic.Pointer[error] // the last write error
+}
+
+// audit writes id to the audit log of f, if it has one, as its length in
+// uvarint encoding followed by its bytes.
+func (f *Filter) audit(id []byte) {
+	l := f.auditLog
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.buf = append(binary.AppendUvarint(l.buf[:0], uint64(len(id))), id...)
+	_, err := l.w.Write(l.buf)
+	l.mu.Unlock()
+	if err != nil {
+		l.failed.Add(1)
+		l.err.Store(&err)
+	}
+}
+
+// AuditErrors returns the number of ids a filter made with WithAuditLog failed
+// to write to its writer, along with the last error, or 0 and nil for any
+// other filter.
+func (f *Filter) AuditErrors() (failed uint64, last error) {
+	l := f.auditLog
+	if l == nil {
+		return 0, nil
+	}
+	if err := l.err.Load(); err != nil {
+		last = *err
+	}
+	return l.failed.Load(), last
+}
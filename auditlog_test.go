@@ -0,0 +1,98 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	f, _ := NewFilter(1024, WithAuditLog(&buf))
+	for _, id := range []string{"a", "b", "a", "", "b", "ccc", "a"} {
+		f.Contains([]byte(id))
+	}
+	var got []string
+	for data := buf.Bytes(); len(data) > 0; {
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			t.Fatalf("audit log is not length-prefixed: %q", buf.Bytes())
+		}
+		got = append(got, string(data[n:n+int(length)]))
+		data = data[n+int(length):]
+	}
+	want := []string{"a", "b", "", "ccc"}
+	if len(got) != len(want) {
+		t.Fatalf("audit log should hold each id once, got: %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("audit log should hold each id once, in order, got: %q", got)
+		}
+	}
+	if failed, err := f.AuditErrors(); failed != 0 || err != nil {
+		t.Errorf("working writer should have no errors, got %d: %v", failed, err)
+	}
+
+	// An id evicted from its slot is logged again when inserted again.
+	buf.Reset()
+	one, _ := NewFilter(1, WithAuditLog(&buf))
+	for _, id := range []string{"a", "b", "a"} {
+		one.Contains([]byte(id))
+	}
+	if buf.String() != "\x01a\x01b\x01a" {
+		t.Errorf("evicted id should be logged again, got: %q", buf.String())
+	}
+	if c := one.Clone(); c.auditLog != nil {
+		t.Errorf("clone should not share the audit log")
+	}
+}
+
+func TestAuditLogPaths(t *testing.T) {
+	var buf bytes.Buffer
+	f, _ := NewFilter(1024, WithAuditLog(&buf))
+	f.Swap([]byte("a"), []byte("b"))
+	f.Swap([]byte("a"), []byte("b"))
+	if buf.String() != "\x01b" {
+		t.Errorf("replacement of Swap should be logged once, got: %q", buf.String())
+	}
+
+	buf.Reset()
+	w, _ := NewFilter2Way(1, WithAuditLog(&buf))
+	for _, id := range []string{"a", "b", "a", "b", "c"} {
+		w.Contains([]byte(id))
+	}
+	if buf.String() != "\x01a\x01b\x01c" {
+		t.Errorf("new ids of a TwoWayFilter should be logged, got: %q", buf.String())
+	}
+
+	if f, err := NewFingerprintFilter(16, WithAuditLog(&buf)); err != ErrAuditFingerprint || f != nil {
+		t.Errorf("fingerprint filter should reject an audit log, got: %v", err)
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write(p []byte) (int, error) { return 0, w.err }
+
+func TestAuditLogErrors(t *testing.T) {
+	errFull := errors.New("disk full")
+	f, _ := NewFilter(16, WithAuditLog(failingWriter{errFull}))
+	f.Contains([]byte("a"))
+	f.Contains([]byte("a"))
+	f.Contains([]byte("b"))
+	if failed, err := f.AuditErrors(); failed != 2 || err != errFull {
+		t.Errorf("both new ids should fail to be logged, got %d: %v", failed, err)
+	}
+	if failed, err := (&Filter{}).AuditErrors(); failed != 0 || err != nil {
+		t.Errorf("filter without an audit log should have no errors, got %d: %v", failed, err)
+	}
+	if _, err := NewFilter(16, WithAuditLog(nil)); err != ErrNilWriter {
+		t.Errorf("nil writer should fail with ErrNilWriter, got: %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// BitFilter tracks the presence of integer ids below a fixed universe with one
+// bit per id. Unlike Filter it is exact: ids never collide, so ContainsInt
+// never reports false for an id that was added and not forgotten. It is safe
+// for concurrent use.
+type BitFilter struct {
+	words    []atomic.Uint64
+	universe uint64
+}
+
+// NewBitFilter returns a BitFilter for the ids 0 through universe-1, taking
+// universe/8 bytes.
+func NewBitFilter(universe int) (*BitFilter, error) {
+	if universe <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	return &BitFilter{
+		words:    make([]atomic.Uint64, (universe+63)/64),
+		universe: uint64(universe),
+	}, nil
+}
+
+// ContainsInt adds x to the filter and returns true if it was already in it.
+// It panics if x is not below the universe.
+func (b *BitFilter) ContainsInt(x uint64) bool {
+	word, bit := b.locate(x)
+	return word.Or(bit)&bit != 0
+}
+
+// ForgetInt removes x from the filter. It panics if x is not below the
+// universe.
+func (b *BitFilter) ForgetInt(x uint64) {
+	word, bit := b.locate(x)
+	word.And(^bit)
+}
+
+// Size returns the universe the filter was created with.
+func (b *BitFilter) Size() int {
+	return int(b.universe)
+}
+
+// Count returns the number of ids in the filter. Like Filter.Count it is a
+// best-effort snapshot.
+func (b *BitFilter) Count() int {
+	n := 0
+	for i := range b.words {
+		n += bits.OnesCount64(b.words[i].Load())
+	}
+	return n
+}
+
+func (b *BitFilter) locate(x uint64) (*atomic.Uint64, uint64) {
+	if x >= b.universe {
+		panic("oppobloom: id outside the BitFilter universe")
+	}
+	return &b.words[x/64], 1 << (x % 64)
+}
@@ -0,0 +1,51 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"testing"
+)
+
+func TestBitFilter(t *testing.T) {
+	const universe = 100
+	b, err := NewBitFilter(universe)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, x := range []uint64{0, 63, 64, universe - 1} {
+		if b.ContainsInt(x) {
+			t.Errorf("fresh id %d should not be contained", x)
+		}
+		if !b.ContainsInt(x) {
+			t.Errorf("seen id %d should be contained", x)
+		}
+	}
+	if b.Count() != 4 || b.Size() != universe {
+		t.Errorf("filter should hold 4 of %d ids, holds %d of %d", universe, b.Count(), b.Size())
+	}
+	b.ForgetInt(0)
+	b.ForgetInt(1)
+	if b.ContainsInt(0) {
+		t.Errorf("forgotten id should not be contained")
+	}
+	if !b.ContainsInt(universe - 1) {
+		t.Errorf("forgetting an id should leave the others")
+	}
+}
+
+func TestBitFilterOutsideUniverse(t *testing.T) {
+	b, _ := NewBitFilter(100)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("id equal to the universe should panic")
+		}
+	}()
+	b.ContainsInt(100)
+}
+
+func TestInvalidBitFilter(t *testing.T) {
+	if b, err := NewBitFilter(0); err != ErrSizeTooSmall || b != nil {
+		t.Errorf("did not error out on a zero universe")
+	}
+}
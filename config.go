@@ -0,0 +1,63 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"encoding/binary"
+	"hash/adler32"
+	"hash/crc32"
+	"hash/fnv"
+	"reflect"
+)
+
+// Config describes how a filter was built, e.g. for admin tools to report it
+// or build another filter like it. HashName is "md5" for NewFilter and the
+// constructors based on it, "siphash" for NewFilterSipHash, and for the hashes
+// of the standard library passed to NewFilterWithHash or NewFilterWithHash64
+// one of "fnv32", "fnv32a", "crc32", "adler32", "fnv64" and "fnv64a". Other
+// hashes are "custom".
+type Config struct {
+	Size     int    // slots
+	HashName string // one of the names above
+	Seed     uint64 // from NewFilterWithSeed, or 0
+	Exact    bool   // made by NewExactSizeFilter
+}
+
+// hashNames maps the entry points of hash factories to their names.
+var hashNames = map[uintptr]string{
+	funcPC(newMD5UintHash): "md5",
+	funcPC(fnv.New32):      "fnv32",
+	funcPC(fnv.New32a):     "fnv32a",
+	funcPC(crc32.NewIEEE):  "crc32",
+	funcPC(adler32.New):    "adler32",
+}
+
+// hash64Names is hashNames for factories passed to NewFilterWithHash64.
+var hash64Names = map[uintptr]string{
+	funcPC(fnv.New64):  "fnv64",
+	funcPC(fnv.New64a): "fnv64a",
+}
+
+func funcPC(fn any) uintptr {
+	return reflect.ValueOf(fn).Pointer()
+}
+
+// nameHash returns the name of the hash factory h, looked up in names.
+func nameHash(names map[uintptr]string, h any) string {
+	if name, ok := names[funcPC(h)]; ok {
+		return name
+	}
+	return "custom"
+}
+
+// Config returns how the filter was built. The size is its current one, which
+// Grow and UnmarshalBinary may have changed.
+func (f *Filter) Config() Config {
+	c := Config{Size: f.Size(), HashName: f.hashName, Exact: f.exact}
+	if f.seed != nil {
+		c.Seed = binary.LittleEndian.Uint64(f.seed)
+	}
+	return c
+}
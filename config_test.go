@@ -0,0 +1,43 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"hash"
+	"hash/crc64"
+	"hash/fnv"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	build := builder(t)
+	crc := func() hash.Hash64 { return crc64.New(crc64.MakeTable(crc64.ISO)) }
+	for _, c := range []struct {
+		filter *Filter
+		want   Config
+	}{
+		{build(NewFilter(1000)), Config{Size: 1024, HashName: "md5"}},
+		{build(NewExactSizeFilter(1000)), Config{Size: 1000, HashName: "md5", Exact: true}},
+		{build(NewFilterWithSeed(16, 42)), Config{Size: 16, HashName: "md5", Seed: 42}},
+		{build(NewFilter(16, WithByteOrder(binary.BigEndian))), Config{Size: 16, HashName: "md5"}},
+		{build(NewFilterWithHash(16, fnv.New32a)), Config{Size: 16, HashName: "fnv32a"}},
+		{build(NewFilterWithHash64(16, fnv.New64)), Config{Size: 16, HashName: "fnv64"}},
+		{build(NewFilterWithHash64(16, crc)), Config{Size: 16, HashName: "custom"}},
+		{build(NewFilterSipHash(16, 1, 2)), Config{Size: 16, HashName: "siphash"}},
+	} {
+		if got := c.filter.Config(); got != c.want {
+			t.Errorf("config should be %+v, got: %+v", c.want, got)
+		}
+	}
+
+	f := build(NewFilter(16))
+	f.Grow()
+	data, _ := f.MarshalBinary()
+	var g Filter
+	g.UnmarshalBinary(data)
+	if want := (Config{Size: 32, HashName: "md5"}); f.Config() != want || g.Config() != want {
+		t.Errorf("config should have the current size, got: %+v and %+v", f.Config(), g.Config())
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"bytes"
+	"hash"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// A CountingFilter is a Filter that also counts how many times each id it
+// holds was added, e.g. to tell an id seen a few times from one seen often.
+// Like Filter, it is lossy: an id evicted by a different one that hashes to
+// its slot is forgotten along with its count, which starts over at 1 if the
+// id comes back. So a count is at most the number of times the id was really
+// added, never more.
+//
+// Counts are incremented in place, but an increment racing with the eviction
+// of its id is lost. Ids are stored as they are passed in, so they must not be
+// changed afterwards.
+type CountingFilter struct {
+	slots  []atomic.Pointer[countedID]
+	mask   uint64
+	hashes *sync.Pool
+}
+
+// countedID is an id held by a CountingFilter and the times it was added.
+type countedID struct {
+	id    []byte
+	count atomic.Uint32
+}
+
+// NewCountingFilter returns a CountingFilter of at least size slots, like
+// NewFilter, which it indexes with MD5.
+func NewCountingFilter(size int) (*CountingFilter, error) {
+	if size <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	size = RoundedSize(size)
+	if size == 0 || size > maxFilterSize {
+		return nil, ErrSizeTooLarge
+	}
+	return &CountingFilter{
+		slots:  make([]atomic.Pointer[countedID], size),
+		mask:   uint64(size - 1),
+		hashes: newHashPool(newMD5UintHash),
+	}, nil
+}
+
+// Contains adds id to the filter and returns the number of times it was added
+// while in the filter, including this time: 1 for an id that was not in it.
+// Counts stop at math.MaxUint32.
+func (c *CountingFilter) Contains(id []byte) uint32 {
+	slot := &c.slots[c.index(id)]
+	var fresh *countedID
+	for {
+		old := slot.Load()
+		if old != nil && bytes.Equal(old.id, id) {
+			return old.increment()
+		}
+		if fresh == nil {
+			fresh = &countedID{id: id}
+			fresh.count.Store(1)
+		}
+		if slot.CompareAndSwap(old, fresh) {
+			return 1
+		}
+	}
+}
+
+// Count returns the number of times id was added while in the filter, or 0 if
+// it is not in it, without adding it.
+func (c *CountingFilter) Count(id []byte) uint32 {
+	if e := c.slots[c.index(id)].Load(); e != nil && bytes.Equal(e.id, id) {
+		return e.count.Load()
+	}
+	return 0
+}
+
+// Size returns the number of slots in the filter.
+func (c *CountingFilter) Size() int {
+	return len(c.slots)
+}
+
+func (c *CountingFilter) index(id []byte) uint64 {
+	h := c.hashes.Get().(hash.Hash32)
+	h.Reset()
+	h.Write(id)
+	index := sum64(h) & c.mask
+	c.hashes.Put(h)
+	return index
+}
+
+// increment adds 1 to e's count, unless it is at math.MaxUint32, and returns
+// the new count.
+func (e *countedID) increment() uint32 {
+	for {
+		n := e.count.Load()
+		if n == math.MaxUint32 {
+			return n
+		}
+		if e.count.CompareAndSwap(n, n+1) {
+			return n + 1
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+func TestCountingFilter(t *testing.T) {
+	c, err := NewCountingFilter(1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a, b := []byte("a"), []byte("b")
+	if n := c.Count(a); n != 0 {
+		t.Errorf("fresh id should have count 0, got: %d", n)
+	}
+	for want := uint32(1); want <= 5; want++ {
+		if n := c.Contains(a); n != want {
+			t.Errorf("id added %d times should have count %d, got: %d", want, want, n)
+		}
+	}
+	if c.Count(a) != 5 || c.Count(a) != 5 {
+		t.Errorf("Count should not add the id, got: %d", c.Count(a))
+	}
+	if n := c.Contains(b); n != 1 || c.Count(a) != 5 {
+		t.Errorf("other id should be counted separately, got: %d", n)
+	}
+	if c.Size() != 1024 {
+		t.Errorf("filter should have 1024 slots, has: %d", c.Size())
+	}
+
+	c.slots[c.index(a)].Load().count.Store(math.MaxUint32)
+	if n := c.Contains(a); n != math.MaxUint32 {
+		t.Errorf("count should stop at the maximum, got: %d", n)
+	}
+
+	if c, err := NewCountingFilter(0); err != ErrSizeTooSmall || c != nil {
+		t.Errorf("did not error out on a zero size")
+	}
+}
+
+func TestCountingFilterEviction(t *testing.T) {
+	// All ids hash to the one slot of the filter.
+	c, _ := NewCountingFilter(1)
+	a, b := []byte("a"), []byte("b")
+	c.Contains(a)
+	c.Contains(a)
+	if n := c.Contains(b); n != 1 || c.Count(a) != 0 {
+		t.Errorf("different id should evict the id and its count, got: %d", n)
+	}
+	if n := c.Contains(a); n != 1 {
+		t.Errorf("evicted id should start over at 1, got: %d", n)
+	}
+}
+
+func TestCountingFilterConcurrent(t *testing.T) {
+	c, _ := NewCountingFilter(1024)
+	id := []byte("shared")
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				c.Contains(id)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := c.Count(id); n != 8000 {
+		t.Errorf("id without collisions should count every add, got: %d", n)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "sync/atomic"
+
+// An EvictionEvent records an insert that evicted a different id from its
+// slot, with the ids as the filter stored them.
+type EvictionEvent struct {
+	Evicted  []byte
+	Inserted []byte
+}
+
+// evictionLog is a ring of the last len(events) evictions. Writers claim a
+// position with next and store their event there without locking.
+type evictionLog struct {
+	next   atomic.Uint64 // evictions logged so far
+	events []atomic.Pointer[EvictionEvent]
+}
+
+// logEviction records that inserted evicted evicted, in a filter made with
+// WithEvictionLog, making the log on the first eviction.
+func (f *Filter) logEviction(evicted, inserted []byte) {
+	l := f.evictionLog.Load()
+	if l == nil {
+		l = &evictionLog{events: make([]atomic.Pointer[EvictionEvent], f.evictionLogSize)}
+		if !f.evictionLog.CompareAndSwap(nil, l) {
+			l = f.evictionLog.Load()
+		}
+	}
+	n := l.next.Add(1) - 1
+	l.events[n%uint64(len(l.events))].Store(&EvictionEvent{Evicted: evicted, Inserted: inserted})
+}
+
+// EvictionLog returns the most recent evictions of a filter made with
+// WithEvictionLog, oldest first, or nil for any other filter. It returns at
+// most the capacity given to WithEvictionLog. Under concurrent inserts the
+// events are in about the order they happened, and an event being logged may
+// be missed or replaced by a newer one.
+func (f *Filter) EvictionLog() []EvictionEvent {
+	l := f.evictionLog.Load()
+	if l == nil {
+		return nil
+	}
+	end := l.next.Load()
+	start := uint64(0)
+	if size := uint64(len(l.events)); end > size {
+		start = end - size
+	}
+	events := make([]EvictionEvent, 0, end-start)
+	for n := start; n < end; n++ {
+		if e := l.events[n%uint64(len(l.events))].Load(); e != nil {
+			events = append(events, *e)
+		}
+	}
+	return events
+}
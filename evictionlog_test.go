@@ -0,0 +1,73 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestEvictionLog(t *testing.T) {
+	f, _ := NewFilter(1, WithEvictionLog(3))
+	if log := f.EvictionLog(); log != nil {
+		t.Errorf("filter without evictions should have no log, got: %v", log)
+	}
+	f.Contains([]byte("a"))
+	f.Contains([]byte("b"))
+	f.Contains([]byte("b"))
+	if log := f.EvictionLog(); len(log) != 1 || string(log[0].Evicted) != "a" || string(log[0].Inserted) != "b" {
+		t.Fatalf("log should hold the one eviction of a by b, got: %q", log)
+	}
+
+	for _, id := range []string{"c", "d", "e", "f"} {
+		f.Contains([]byte(id))
+	}
+	log := f.EvictionLog()
+	want := []string{"c>d", "d>e", "e>f"}
+	if len(log) != len(want) {
+		t.Fatalf("log should hold the last %d evictions, got: %q", len(want), log)
+	}
+	for i, e := range log {
+		if got := string(e.Evicted) + ">" + string(e.Inserted); got != want[i] {
+			t.Errorf("eviction %d should be %s, got: %s", i, want[i], got)
+		}
+	}
+
+	g, _ := NewFilter(1)
+	g.Contains([]byte("a"))
+	g.Contains([]byte("b"))
+	if log := g.EvictionLog(); log != nil {
+		t.Errorf("filter without WithEvictionLog should have no log, got: %v", log)
+	}
+	if _, err := NewFilter(1, WithEvictionLog(0)); err != ErrInvalidLogCapacity {
+		t.Errorf("capacity 0 should fail with ErrInvalidLogCapacity, got: %v", err)
+	}
+}
+
+func TestEvictionLogConcurrent(t *testing.T) {
+	f, _ := NewFilter(4, WithEvictionLog(16))
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				f.Contains([]byte(fmt.Sprint(w, i)))
+				f.EvictionLog()
+			}
+		}(w)
+	}
+	wg.Wait()
+	log := f.EvictionLog()
+	if len(log) != 16 {
+		t.Fatalf("log should be full, holds: %d", len(log))
+	}
+	for _, e := range log {
+		if e.Evicted == nil || e.Inserted == nil {
+			t.Errorf("logged eviction should have both ids, got: %q", e)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "crypto/md5"
+
+// NewFingerprintFilter returns a filter of at least size slots that stores the
+// 16 byte MD5 digest of each id instead of the id itself, so that its memory
+// use doesn't grow with the length of the ids. Ids are compared by digest, so
+// unlike other filters it reports a false positive for two ids with the same
+// digest. For accidental collisions between MD5 digests that is negligible,
+// but ids crafted to collide can be told apart only by an unfingerprinted
+// filter.
+//
+// Stored ids, as returned from ContainsEvict or OnEvict, are the digests. As
+// an audit log would only record digests too, it returns ErrAuditFingerprint
+// if opts include WithAuditLog.
+func NewFingerprintFilter(size int, opts ...Option) (*Filter, error) {
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if f.auditLog != nil {
+		return nil, ErrAuditFingerprint
+	}
+	f.fingerprint = true
+	return f, nil
+}
+
+// key returns what the filter stores for id, which is id itself unless the
+// filter is a fingerprint filter. The slot of a fingerprint is derived from
+// the fingerprint, so it can be found again without the id.
+func (f *Filter) key(id []byte) []byte {
+	if !f.fingerprint {
+		return id
+	}
+	h := md5.New()
+	h.Write(f.seed)
+	h.Write(id)
+	return h.Sum(make([]byte, 0, md5.Size))
+}
@@ -0,0 +1,62 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"bytes"
+	"testing"
+)
+
+// storedBytes returns the total length of the ids stored in f.
+func storedBytes(f *Filter) int {
+	n := 0
+	f.Range(func(id []byte) bool {
+		n += len(id)
+		return true
+	})
+	return n
+}
+
+func TestFingerprintFilter(t *testing.T) {
+	f, err := NewFingerprintFilter(1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	id := bytes.Repeat([]byte{1}, 4096)
+	shouldNotContain(t, "fresh id in a fingerprint filter", f, id)
+	shouldContain(t, "seen id in a fingerprint filter", f, id)
+	if !f.Peek(id) {
+		t.Errorf("peek should find the id by its fingerprint")
+	}
+	other := bytes.Repeat([]byte{2}, 4096)
+	if f.ContainsString(string(other)) {
+		t.Errorf("different id should not be contained")
+	}
+	if !f.Contains(other) {
+		t.Errorf("string id should be found by its fingerprint")
+	}
+	f.Forget(id)
+	shouldNotContain(t, "forgotten id in a fingerprint filter", f, id)
+
+	g, _ := f.Resize(4096)
+	if !g.Peek(id) || !g.Peek(other) {
+		t.Errorf("resized fingerprint filter should keep its ids")
+	}
+}
+
+func TestFingerprintMemory(t *testing.T) {
+	plain, _ := NewFilter(1 << 12)
+	fingerprinted, _ := NewFingerprintFilter(1 << 12)
+	for i := 0; i < 100; i++ {
+		id := bytes.Repeat([]byte{byte(i)}, 1024)
+		plain.Contains(id)
+		fingerprinted.Contains(id)
+	}
+	if n := storedBytes(fingerprinted); n > 100*16 {
+		t.Errorf("fingerprint filter should store at most 16 bytes per id, stored: %d", n)
+	}
+	if n := storedBytes(plain); n < 90*1024 {
+		t.Errorf("plain filter should store whole ids, stored: %d", n)
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrInvalidWidth = errors.New("oppobloom: id width must be positive")
+
+// Bits of a FixedWidthFilter slot's state. The rest count the slot's writes,
+// so that readers can tell the slot changed while they read it.
+const (
+	slotLocked   = 1 << iota // a writer is changing the slot
+	slotOccupied             // the slot holds an id
+	slotWrites
+)
+
+// A FixedWidthFilter is a Filter for ids that all have the same width, such
+// as 16 byte UUIDs, which it stores in one flat array of words instead of one
+// slice per id. A slot takes width rounded up to 8 bytes, plus 8, where an
+// occupied Filter slot takes a pointer, a slice header and the id itself, the
+// last two allocated separately for the garbage collector to track: 24 bytes
+// instead of about 48 for UUIDs, in memory the garbage collector need not
+// scan. Ids are copied into the array, so unlike with Filter they may be
+// changed afterwards.
+//
+// Peek reads a slot without locking, retrying if it changed meanwhile, but
+// Contains and Forget lock the slot while they change it, so that its words
+// are always changed together.
+type FixedWidthFilter struct {
+	states []atomic.Uint64 // slotLocked, slotOccupied and the count of writes of each slot
+	words  []atomic.Uint64 // stride words per slot, holding its id in little-endian order
+	width  int
+	stride int
+	mask   uint64
+	hashes *sync.Pool
+}
+
+// NewFixedWidthFilter returns a FixedWidthFilter of at least size slots, like
+// NewFilter, for ids of width bytes, which it indexes with MD5.
+func NewFixedWidthFilter(size, width int) (*FixedWidthFilter, error) {
+	if width <= 0 {
+		return nil, ErrInvalidWidth
+	}
+	if size <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	stride := (width + 7) / 8
+	size = RoundedSize(size)
+	if size == 0 || size > maxFilterSize/(stride+1) {
+		return nil, ErrSizeTooLarge
+	}
+	return &FixedWidthFilter{
+		states: make([]atomic.Uint64, size),
+		words:  make([]atomic.Uint64, size*stride),
+		width:  width,
+		stride: stride,
+		mask:   uint64(size - 1),
+		hashes: newHashPool(newMD5UintHash),
+	}, nil
+}
+
+// Contains adds id to the filter and returns true if it was already in it. It
+// panics if id is not width bytes long.
+func (w *FixedWidthFilter) Contains(id []byte) bool {
+	i := w.index(id)
+	state := w.lock(i)
+	if state&slotOccupied != 0 && w.equal(i, id) {
+		w.states[i].Store(state)
+		return true
+	}
+	w.store(i, id)
+	w.states[i].Store(state&^(slotWrites-1) + slotWrites | slotOccupied)
+	return false
+}
+
+// Peek returns true if id is in the filter, without adding it. It panics if id
+// is not width bytes long.
+func (w *FixedWidthFilter) Peek(id []byte) bool {
+	i := w.index(id)
+	for {
+		state := w.states[i].Load()
+		if state&slotLocked != 0 {
+			runtime.Gosched()
+			continue
+		}
+		if state&slotOccupied == 0 {
+			return false
+		}
+		equal := w.equal(i, id)
+		if w.states[i].Load() == state {
+			return equal
+		}
+	}
+}
+
+// Forget removes id if it is in the filter. It panics if id is not width bytes
+// long.
+func (w *FixedWidthFilter) Forget(id []byte) {
+	i := w.index(id)
+	state := w.lock(i)
+	if state&slotOccupied != 0 && w.equal(i, id) {
+		state = state&^(slotWrites-1) + slotWrites
+	}
+	w.states[i].Store(state)
+}
+
+// Contains16 is Contains for a 16 byte id, such as a UUID or an MD5 digest,
+// passed as an array so that it is hashed and compared as two words, without
+// allocating. It panics if the filter's width is not 16. An id is the same id
+// whether it is passed to Contains16 or to Contains as a slice.
+func (w *FixedWidthFilter) Contains16(id [16]byte) bool {
+	i, lo, hi := w.index16(id)
+	state := w.lock(i)
+	if state&slotOccupied != 0 && w.equal16(i, lo, hi) {
+		w.states[i].Store(state)
+		return true
+	}
+	w.words[2*i].Store(lo)
+	w.words[2*i+1].Store(hi)
+	w.states[i].Store(state&^(slotWrites-1) + slotWrites | slotOccupied)
+	return false
+}
+
+// Peek16 is Peek for a 16 byte id, like Contains16.
+func (w *FixedWidthFilter) Peek16(id [16]byte) bool {
+	i, lo, hi := w.index16(id)
+	for {
+		state := w.states[i].Load()
+		if state&slotLocked != 0 {
+			runtime.Gosched()
+			continue
+		}
+		if state&slotOccupied == 0 {
+			return false
+		}
+		equal := w.equal16(i, lo, hi)
+		if w.states[i].Load() == state {
+			return equal
+		}
+	}
+}
+
+// Forget16 is Forget for a 16 byte id, like Contains16.
+func (w *FixedWidthFilter) Forget16(id [16]byte) {
+	i, lo, hi := w.index16(id)
+	state := w.lock(i)
+	if state&slotOccupied != 0 && w.equal16(i, lo, hi) {
+		state = state&^(slotWrites-1) + slotWrites
+	}
+	w.states[i].Store(state)
+}
+
+// Size returns the number of slots in the filter.
+func (w *FixedWidthFilter) Size() int {
+	return len(w.states)
+}
+
+// Width returns the width of the filter's ids.
+func (w *FixedWidthFilter) Width() int {
+	return w.width
+}
+
+// Count returns the number of slots holding an id. Like Filter.Count it is a
+// best-effort snapshot.
+func (w *FixedWidthFilter) Count() int {
+	n := 0
+	for i := range w.states {
+		if w.states[i].Load()&slotOccupied != 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (w *FixedWidthFilter) index(id []byte) int {
+	if len(id) != w.width {
+		panic("oppobloom: id is not as wide as the FixedWidthFilter")
+	}
+	h := w.hashes.Get().(hash.Hash32)
+	h.Reset()
+	h.Write(id)
+	index := sum64(h) & w.mask
+	w.hashes.Put(h)
+	return int(index)
+}
+
+// lock spins until it sets slotLocked in the state of slot i, returning the
+// state from before.
+func (w *FixedWidthFilter) lock(i int) uint64 {
+	for {
+		state := w.states[i].Load()
+		if state&slotLocked == 0 && w.states[i].CompareAndSwap(state, state|slotLocked) {
+			return state
+		}
+		runtime.Gosched()
+	}
+}
+
+// word returns the jth word of id, padded with zeros.
+func (w *FixedWidthFilter) word(id []byte, j int) uint64 {
+	var b [8]byte
+	copy(b[:], id[j*8:])
+	return binary.LittleEndian.Uint64(b[:])
+}
+
+func (w *FixedWidthFilter) equal(i int, id []byte) bool {
+	words := w.words[i*w.stride : (i+1)*w.stride]
+	for j := range words {
+		if words[j].Load() != w.word(id, j) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *FixedWidthFilter) store(i int, id []byte) {
+	words := w.words[i*w.stride : (i+1)*w.stride]
+	for j := range words {
+		words[j].Store(w.word(id, j))
+	}
+}
+
+// index16 is index for a 16 byte id, also returning its two words. It hashes
+// id with md5.Sum and folds the digest like foldDigest64, but with a concrete
+// byte order, so that neither id nor the digest escapes to the heap.
+func (w *FixedWidthFilter) index16(id [16]byte) (i int, lo, hi uint64) {
+	if w.width != 16 {
+		panic("oppobloom: FixedWidthFilter is not 16 bytes wide")
+	}
+	sum := md5.Sum(id[:])
+	le := binary.LittleEndian
+	x := le.Uint32(sum[0:]) ^ le.Uint32(sum[4:])
+	i = int((uint64(x)<<32 | uint64(x^le.Uint32(sum[8:])^le.Uint32(sum[12:]))) & w.mask)
+	return i, binary.LittleEndian.Uint64(id[:8]), binary.LittleEndian.Uint64(id[8:])
+}
+
+// equal16 is equal for the two words of a 16 byte id.
+func (w *FixedWidthFilter) equal16(i int, lo, hi uint64) bool {
+	return w.words[2*i].Load()^lo|w.words[2*i+1].Load()^hi == 0
+}
@@ -0,0 +1,211 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func uuid(i int) []byte {
+	return binary.BigEndian.AppendUint64(binary.BigEndian.AppendUint64(nil, uint64(i)), ^uint64(i))
+}
+
+func TestFixedWidthFilter(t *testing.T) {
+	w, err := NewFixedWidthFilter(1<<16, 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	id := uuid(27)
+	if w.Peek(id) || w.Contains(id) {
+		t.Errorf("fresh id should not be contained")
+	}
+	if !w.Peek(id) || !w.Contains(id) {
+		t.Errorf("seen id should be contained")
+	}
+	id[0] = 1
+	if w.Peek(id) {
+		t.Errorf("ids should be copied into the filter")
+	}
+	id[0] = 0
+	w.Forget(id)
+	if w.Peek(id) || w.Count() != 0 {
+		t.Errorf("forgotten id should not be contained")
+	}
+	if w.Size() != 1<<16 || w.Width() != 16 {
+		t.Errorf("filter should have %d slots of width 16, got: %d of %d", 1<<16, w.Size(), w.Width())
+	}
+
+	// Ids of a width that is not a multiple of 8 behave like the ids of a
+	// Filter.
+	odd, _ := NewFixedWidthFilter(4, 5)
+	f, _ := NewFilter(4)
+	for i := 0; i < 100; i++ {
+		id := []byte{byte(i % 7), 0, 0, 0, byte(i % 3)}
+		if i%5 == 4 {
+			odd.Forget(id)
+			f.Forget(id)
+			continue
+		}
+		if got, want := odd.Contains(id), f.Contains(id); got != want {
+			t.Fatalf("Contains(%v) = %v, Filter returned %v", id, got, want)
+		}
+	}
+	if odd.Count() != f.Count() {
+		t.Errorf("filter should hold %d ids like Filter, holds: %d", f.Count(), odd.Count())
+	}
+}
+
+func TestFixedWidthFilterWrongWidth(t *testing.T) {
+	w, _ := NewFixedWidthFilter(16, 16)
+	defer func() {
+		if recover() == nil {
+			t.Errorf("id of the wrong width should panic")
+		}
+	}()
+	w.Contains([]byte{1, 2, 3})
+}
+
+func TestInvalidFixedWidthFilter(t *testing.T) {
+	for _, c := range []struct {
+		size, width int
+		err         error
+	}{{16, 0, ErrInvalidWidth}, {0, 16, ErrSizeTooSmall}, {maxFilterSize, 16, ErrSizeTooLarge}} {
+		if w, err := NewFixedWidthFilter(c.size, c.width); err != c.err || w != nil {
+			t.Errorf("NewFixedWidthFilter(%d, %d) should fail with %v, got: %v", c.size, c.width, c.err, err)
+		}
+	}
+}
+
+func TestFixedWidthFilterConcurrent(t *testing.T) {
+	w, _ := NewFixedWidthFilter(8, 16)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := uuid(i % 16)
+				w.Contains(id)
+				w.Peek(id)
+				if i%3 == g%3 {
+					w.Forget(id)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 16; i++ {
+		w.Forget(uuid(i))
+	}
+	if w.Count() != 0 {
+		t.Errorf("filter should be empty after forgetting every id, holds: %d", w.Count())
+	}
+}
+
+// benchmarkUUIDs fills a filter of 1<<16 slots with UUIDs and then looks them
+// up, reporting the memory the filled filter takes per slot.
+func benchmarkUUIDs(b *testing.B, newFilter func() func(id []byte) bool) {
+	ids := make([][]byte, 1<<16)
+	for i := range ids {
+		ids[i] = uuid(i)
+	}
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	contains := newFilter()
+	for _, id := range ids {
+		contains(id)
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contains(ids[i%len(ids)])
+	}
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc)/float64(len(ids)), "B/slot")
+	runtime.KeepAlive(contains)
+}
+
+func BenchmarkUUIDsFilter(b *testing.B) {
+	benchmarkUUIDs(b, func() func(id []byte) bool {
+		f, _ := NewFilter(1<<16, WithCopyOnInsert())
+		return f.Contains
+	})
+}
+
+func BenchmarkUUIDsFixedWidthFilter(b *testing.B) {
+	benchmarkUUIDs(b, func() func(id []byte) bool {
+		w, _ := NewFixedWidthFilter(1<<16, 16)
+		return w.Contains
+	})
+}
+
+func TestFixedWidthFilter16(t *testing.T) {
+	w, _ := NewFixedWidthFilter(1<<16, 16)
+	var ids [][16]byte
+	for i := 0; i < 16; i++ {
+		ids = append(ids, [16]byte(uuid(i)))
+	}
+	for _, id := range ids {
+		if w.Contains16(id) {
+			t.Fatalf("fresh id should not be contained")
+		}
+	}
+	for i, id := range ids {
+		if !w.Peek16(id) || !w.Peek(uuid(i)) {
+			t.Fatalf("array and slice of an id should both find it")
+		}
+	}
+	w.Forget16(ids[0])
+	w.Forget(uuid(1))
+	if w.Peek16(ids[0]) || w.Peek16(ids[1]) || !w.Peek16(ids[2]) {
+		t.Errorf("forgetting an id should only remove it")
+	}
+	if !w.Contains(uuid(3)) || w.Contains(uuid(0)) || !w.Contains16(ids[0]) {
+		t.Errorf("Contains should see ids added by Contains16 and the other way round")
+	}
+	if n := testing.AllocsPerRun(100, func() { w.Contains16(ids[5]) }); n != 0 {
+		t.Errorf("Contains16 should not allocate, got: %v", n)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Contains16 should panic on a filter of another width")
+		}
+	}()
+	narrow, _ := NewFixedWidthFilter(16, 8)
+	narrow.Contains16(ids[0])
+}
+
+// benchmarkDigests looks up 16 byte ids already in a filter of 1<<16 slots.
+func benchmarkDigests(b *testing.B, contains func(id []byte) bool) {
+	ids := make([][]byte, 1<<12)
+	for i := range ids {
+		ids[i] = uuid(i)
+		contains(ids[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contains(ids[i%len(ids)])
+	}
+}
+
+func BenchmarkDigestsFilter(b *testing.B) {
+	f, _ := NewFilter(1 << 16)
+	benchmarkDigests(b, f.Contains)
+}
+
+func BenchmarkDigestsFixedWidthFilter(b *testing.B) {
+	w, _ := NewFixedWidthFilter(1<<16, 16)
+	benchmarkDigests(b, w.Contains)
+}
+
+func BenchmarkDigestsContains16(b *testing.B) {
+	w, _ := NewFixedWidthFilter(1<<16, 16)
+	benchmarkDigests(b, func(id []byte) bool { return w.Contains16([16]byte(id)) })
+}
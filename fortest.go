@@ -0,0 +1,23 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+// NewFilterForTest returns a filter of at least size slots that puts each id
+// in the slot index(id), masked to the filter's size, instead of hashing it.
+// This lets tests place ids in the same or different slots on purpose. It is
+// meant for testing only: index is called on every lookup and usually spreads
+// ids much worse than a hash. Integer ids from ContainsUint64 are still mixed
+// as usual.
+func NewFilterForTest(size int, index func(id []byte) int, opts ...Option) (*Filter, error) {
+	if index == nil {
+		return nil, ErrNilHash
+	}
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	f.index = index
+	return f, nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"testing"
+)
+
+func TestNewFilterForTest(t *testing.T) {
+	a := []byte{1}
+	b := []byte{2}
+
+	same, err := NewFilterForTest(16, func([]byte) int { return 3 })
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	same.Contains(a)
+	if present, evicted := same.ContainsEvict(b); present || string(evicted) != string(a) {
+		t.Errorf("ids forced into the same slot should collide, evicted: %v", evicted)
+	}
+	if _, index, _ := same.ContainsWithIndex(a); index != 3 {
+		t.Errorf("id should be in the forced slot 3, is in: %d", index)
+	}
+	same.Forget(b)
+	if !same.Peek(a) {
+		t.Errorf("forgetting an id whose slot holds another should leave it")
+	}
+
+	apart, _ := NewFilterForTest(16, func(id []byte) int { return int(id[0]) + 16 })
+	apart.Contains(a)
+	apart.ContainsString(string(b))
+	if !apart.Peek(a) || !apart.Peek(b) || apart.Count() != 2 {
+		t.Errorf("ids forced into different slots should both be contained")
+	}
+	if _, index, _ := apart.ContainsWithIndex(b); index != 2 {
+		t.Errorf("forced slot should be masked to 2, is: %d", index)
+	}
+
+	if f, err := NewFilterForTest(16, nil); err != ErrNilHash || f != nil {
+		t.Errorf("did not error out on a nil index function")
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var ErrInvalidWindows = errors.New("oppobloom: number of windows to keep is negative or too large")
+
+// A GenerationalFilter is a Filter whose ids are treated as new again once
+// they were last seen more than keepWindows generations ago. The generation
+// only moves on when Rotate is called, e.g. at the start of every window, so
+// ids expire without a clock or a sweeper, at the cost of 4 bytes per slot.
+type GenerationalFilter struct {
+	filter     *Filter
+	seen       []atomic.Uint32 // the generation in which each slot's id was last seen
+	generation atomic.Uint32
+	keep       uint32
+}
+
+// NewGenerationalFilter returns a GenerationalFilter of at least size slots
+// whose ids expire keepWindows generations after the one they were last seen
+// in. With a keepWindows of 0, ids are only contained in their own generation.
+// Like NewTTLFilter, it returns ErrGrowUnsupported if opts include
+// WithAutoGrow.
+func NewGenerationalFilter(size, keepWindows int, opts ...Option) (*GenerationalFilter, error) {
+	if keepWindows < 0 || uint64(keepWindows) >= 1<<31 {
+		return nil, ErrInvalidWindows
+	}
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if f.autoGrow > 0 {
+		return nil, ErrGrowUnsupported
+	}
+	return &GenerationalFilter{filter: f, seen: make([]atomic.Uint32, f.Size()), keep: uint32(keepWindows)}, nil
+}
+
+// Contains adds id to the filter and then returns true if id already existed
+// and was last seen no more than keepWindows generations ago. Either way, id
+// is now seen in the current generation.
+//
+// Like TTLFilter.Contains, a call racing with another on the same slot can see
+// the id of one and the generation of the other.
+func (g *GenerationalFilter) Contains(id []byte) bool {
+	id = g.filter.key(id)
+	index := g.filter.caculateIndex(id)
+	current := g.generation.Load()
+	present, _ := g.filter.insert(index, id)
+	seen := g.seen[index].Swap(current)
+	// Generations wrap around, which only matters for an id seen again
+	// after 2^32 rotations.
+	return present && current-seen <= g.keep
+}
+
+// Rotate starts a new generation and returns it.
+func (g *GenerationalFilter) Rotate() uint32 {
+	return g.generation.Add(1)
+}
+
+// Generation returns the current generation, which starts at 0.
+func (g *GenerationalFilter) Generation() uint32 {
+	return g.generation.Load()
+}
+
+// Forget removes id if it is in the filter.
+func (g *GenerationalFilter) Forget(id []byte) {
+	g.filter.Forget(id)
+}
+
+// Size returns the number of slots in the filter.
+func (g *GenerationalFilter) Size() int {
+	return g.filter.Size()
+}
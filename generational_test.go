@@ -0,0 +1,65 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"testing"
+)
+
+func TestGenerationalFilter(t *testing.T) {
+	f, err := NewGenerationalFilter(1024, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	id, other := []byte{27, 28, 29}, []byte{27, 28, 30}
+	if f.Contains(id) {
+		t.Errorf("fresh id should not be contained")
+	}
+	f.Contains(other)
+	if g := f.Rotate(); g != 1 || f.Generation() != 1 {
+		t.Errorf("first rotation should start generation 1, got: %d", g)
+	}
+	if !f.Contains(id) {
+		t.Errorf("id seen a generation ago should be contained")
+	}
+	f.Rotate()
+	f.Rotate()
+	if !f.Contains(id) {
+		t.Errorf("seeing an id should renew it")
+	}
+	if f.Contains(other) {
+		t.Errorf("id seen 3 generations ago should be new again")
+	}
+	if !f.Contains(other) {
+		t.Errorf("expired id should be seen again")
+	}
+	f.Forget(id)
+	if f.Contains(id) {
+		t.Errorf("forgotten id should not be contained")
+	}
+
+	current, _ := NewGenerationalFilter(1024, 0)
+	current.Contains(id)
+	if !current.Contains(id) {
+		t.Errorf("id seen in the current generation should be contained")
+	}
+	current.Rotate()
+	if current.Contains(id) {
+		t.Errorf("id seen in the previous generation should be new with no windows kept")
+	}
+}
+
+func TestInvalidWindows(t *testing.T) {
+	f, err := NewGenerationalFilter(1024, -1)
+	if err != ErrInvalidWindows || f != nil {
+		t.Errorf("did not error out on a negative number of windows")
+	}
+}
+
+func TestGenerationalFilterAutoGrow(t *testing.T) {
+	g, err := NewGenerationalFilter(16, 2, WithAutoGrow(0.5))
+	if err != ErrGrowUnsupported || g != nil {
+		t.Errorf("did not error out on WithAutoGrow, got: %v", err)
+	}
+}
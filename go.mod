@@ -0,0 +1,3 @@
+module github.com/nvcnvn/oppobloom
+
+go 1.23
@@ -0,0 +1,40 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build largefilter
+
+// The tests in this file allocate filters of several gigabytes and are only
+// run with `go test -tags largefilter`.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestLargerThan30Bits(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large filter in short mode")
+	}
+	f, err := NewFilter((1 << 30) + 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if f.Size() != 1<<31 {
+		t.Errorf("(1<<30)+1 should round to 1<<31, rounded to: %d", f.Size())
+	}
+	high := false
+	id := make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		binary.LittleEndian.PutUint64(id, uint64(i))
+		if f.caculateIndex(id) >= 1<<30 {
+			high = true
+		}
+		shouldNotContain(t, "fresh id in a large filter", f, id)
+		shouldContain(t, "seen id in a large filter", f, id)
+	}
+	if !high {
+		t.Errorf("no index used the high bit of the mask")
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "container/list"
+
+// A LocalCache remembers the last ids passed to its Contains in front of a
+// Filter, so that probing an id again, as code handling one request often
+// does, costs a map lookup instead of hashing it and swapping its slot. It is
+// not safe for concurrent use; make one per goroutine or request instead.
+//
+// An id in the cache is reported as present without going to the filter, so
+// it stays present for the cache even if the filter evicts or forgets it
+// meanwhile.
+type LocalCache struct {
+	filter *Filter
+	size   int
+	order  *list.List               // of the cached ids, most recently used first
+	ids    map[string]*list.Element // from each cached id to its element in order
+}
+
+// NewLocalCache returns a LocalCache of the last size ids passed to it in
+// front of f.
+func NewLocalCache(f *Filter, size int) (*LocalCache, error) {
+	if size <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	return &LocalCache{
+		filter: f,
+		size:   size,
+		order:  list.New(),
+		ids:    make(map[string]*list.Element, size),
+	}, nil
+}
+
+// Contains returns true if id is in the cache. Otherwise it returns
+// f.Contains(id) and caches id, dropping the least recently used id if the
+// cache is full.
+func (c *LocalCache) Contains(id []byte) bool {
+	if e, ok := c.ids[string(id)]; ok {
+		c.order.MoveToFront(e)
+		return true
+	}
+	present := c.filter.Contains(id)
+	if c.order.Len() == c.size {
+		oldest := c.order.Back()
+		delete(c.ids, oldest.Value.(string))
+		c.order.Remove(oldest)
+	}
+	key := string(id)
+	c.ids[key] = c.order.PushFront(key)
+	return present
+}
@@ -0,0 +1,43 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "testing"
+
+func TestLocalCache(t *testing.T) {
+	f, _ := NewFilter(1024)
+	f.Contains([]byte("old"))
+	c, err := NewLocalCache(f, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !c.Contains([]byte("old")) || c.Contains([]byte("a")) {
+		t.Fatalf("cache misses should be answered by the filter")
+	}
+	for i := 0; i < 5; i++ {
+		if !c.Contains([]byte("a")) {
+			t.Fatalf("cached id should be present")
+		}
+	}
+	if n := f.Inserts(); n != 3 {
+		t.Errorf("repeated probes should reach the filter once, inserts: %d", n)
+	}
+
+	// "a" was used last, so "b" drops "old".
+	c.Contains([]byte("b"))
+	c.Contains([]byte("a"))
+	c.Contains([]byte("old"))
+	if n := f.Inserts(); n != 5 {
+		t.Errorf("least recently used id should be dropped, inserts: %d", n)
+	}
+	a := []byte("a")
+	if n := testing.AllocsPerRun(100, func() { c.Contains(a) }); n != 0 {
+		t.Errorf("cache hit should not allocate, got: %v", n)
+	}
+
+	if c, err := NewLocalCache(f, 0); err != ErrSizeTooSmall || c != nil {
+		t.Errorf("did not error out on a zero size")
+	}
+}
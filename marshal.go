@@ -0,0 +1,221 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidSnapshot = errors.New("oppobloom: invalid snapshot")
+
+// snapshotVersion is the first byte of every snapshot.
+const snapshotVersion = 1
+
+// defaultSnapshotLimit is the most slots UnmarshalBinary and UnmarshalJSON
+// allocate for a snapshot larger than their receiver.
+const defaultSnapshotLimit = 1 << 20
+
+// MarshalBinary encodes the filter as its size followed by the index and
+// contents of every slot holding an id. Slots are read one at a time, so a
+// snapshot taken while other goroutines modify the filter is not atomic: it
+// may mix slots from before and after their changes.
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	t := f.table.Load()
+	data := []byte{snapshotVersion}
+	data = binary.AppendUvarint(data, uint64(len(t.slots)))
+	for i := range t.slots {
+		p := t.slot(i)
+		if p == nil {
+			continue
+		}
+		id := *p
+		data = binary.AppendUvarint(data, uint64(i))
+		data = binary.AppendUvarint(data, uint64(len(id)))
+		data = append(data, id...)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary replaces the contents of the filter with a snapshot made by
+// MarshalBinary. The filter keeps its hash, or uses MD5 if it has none, and
+// must not be used by other goroutines until UnmarshalBinary returns. Entries
+// are placed at the indexes they were taken from. Snapshots that don't decode,
+// such as ones with an entry beyond their size, return an error wrapping
+// ErrInvalidSnapshot and leave the filter unchanged, so that snapshots from
+// untrusted sources can be loaded safely. So do snapshots of more slots than
+// both the filter and 2^20, which are rejected before their slots are
+// allocated; UnmarshalBinaryLimit loads larger ones.
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	return f.UnmarshalBinaryLimit(data, f.snapshotLimit())
+}
+
+// UnmarshalBinaryLimit is UnmarshalBinary but rejects snapshots of more than
+// maxSize slots instead, whatever the size of the filter.
+func (f *Filter) UnmarshalBinaryLimit(data []byte, maxSize int) error {
+	if len(data) == 0 || data[0] != snapshotVersion {
+		return ErrInvalidSnapshot
+	}
+	data = data[1:]
+	size, n := binary.Uvarint(data)
+	if n <= 0 || !validSnapshotSize(size, f.exact) {
+		return ErrInvalidSnapshot
+	}
+	if err := checkSnapshotLimit(size, maxSize); err != nil {
+		return err
+	}
+	data = data[n:]
+	array := make([]atomic.Pointer[[]byte], size)
+	occupied := int64(0)
+	for len(data) > 0 {
+		index, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrInvalidSnapshot
+		}
+		if index >= size {
+			return indexOutOfRange(index, size)
+		}
+		data = data[n:]
+		length, n := binary.Uvarint(data)
+		if n <= 0 || length > uint64(len(data)-n) {
+			return ErrInvalidSnapshot
+		}
+		data = data[n:]
+		id := append([]byte{}, data[:length]...)
+		if array[index].Load() == nil {
+			occupied++
+		}
+		array[index].Store(&id)
+		data = data[length:]
+	}
+	f.restore(array, occupied)
+	return nil
+}
+
+// GobEncode encodes the filter for encoding/gob, e.g. as an argument of a
+// net/rpc call, in the format of MarshalBinary.
+func (f *Filter) GobEncode() ([]byte, error) {
+	return f.MarshalBinary()
+}
+
+// GobDecode replaces the contents of the filter with a snapshot made by
+// GobEncode, the same way UnmarshalBinary does.
+func (f *Filter) GobDecode(data []byte) error {
+	return f.UnmarshalBinary(data)
+}
+
+type jsonSnapshot struct {
+	Size    uint64      `json:"size"`
+	Entries []jsonEntry `json:"entries"`
+}
+
+type jsonEntry struct {
+	Index uint64 `json:"index"`
+	ID    []byte `json:"id"`
+}
+
+// MarshalJSON encodes the filter as its size and the index and base64-encoded
+// contents of every slot holding an id. Like MarshalBinary, it is not atomic.
+func (f *Filter) MarshalJSON() ([]byte, error) {
+	t := f.table.Load()
+	snapshot := jsonSnapshot{Size: uint64(len(t.slots)), Entries: []jsonEntry{}}
+	for i := range t.slots {
+		if p := t.slot(i); p != nil {
+			snapshot.Entries = append(snapshot.Entries, jsonEntry{Index: uint64(i), ID: *p})
+		}
+	}
+	return json.Marshal(snapshot)
+}
+
+// UnmarshalJSON replaces the contents of the filter with a snapshot made by
+// MarshalJSON, the same way UnmarshalBinary does.
+func (f *Filter) UnmarshalJSON(data []byte) error {
+	return f.UnmarshalJSONLimit(data, f.snapshotLimit())
+}
+
+// UnmarshalJSONLimit is UnmarshalJSON but rejects snapshots of more than
+// maxSize slots instead, like UnmarshalBinaryLimit.
+func (f *Filter) UnmarshalJSONLimit(data []byte, maxSize int) error {
+	var snapshot jsonSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return err
+	}
+	if !validSnapshotSize(snapshot.Size, f.exact) {
+		return ErrInvalidSnapshot
+	}
+	if err := checkSnapshotLimit(snapshot.Size, maxSize); err != nil {
+		return err
+	}
+	array := make([]atomic.Pointer[[]byte], snapshot.Size)
+	occupied := int64(0)
+	for _, e := range snapshot.Entries {
+		if e.Index >= snapshot.Size {
+			return indexOutOfRange(e.Index, snapshot.Size)
+		}
+		id := e.ID
+		if id == nil {
+			id = []byte{}
+		}
+		if array[e.Index].Load() == nil {
+			occupied++
+		}
+		array[e.Index].Store(&id)
+	}
+	f.restore(array, occupied)
+	return nil
+}
+
+// indexOutOfRange returns the error for an entry of a snapshot of size slots
+// at index, which is not one of them.
+func indexOutOfRange(index, size uint64) error {
+	return fmt.Errorf("%w: index %d out of range for %d slots", ErrInvalidSnapshot, index, size)
+}
+
+// snapshotLimit returns the most slots UnmarshalBinary loads into f: its size,
+// or defaultSnapshotLimit if that is larger or f has no slots yet.
+func (f *Filter) snapshotLimit() int {
+	if t := f.table.Load(); t != nil && len(t.slots) > defaultSnapshotLimit {
+		return len(t.slots)
+	}
+	return defaultSnapshotLimit
+}
+
+// checkSnapshotLimit returns an error wrapping ErrInvalidSnapshot if a
+// snapshot of size slots exceeds maxSize.
+func checkSnapshotLimit(size uint64, maxSize int) error {
+	if maxSize < 0 || size > uint64(maxSize) {
+		return fmt.Errorf("%w: %d slots exceed the limit of %d", ErrInvalidSnapshot, size, maxSize)
+	}
+	return nil
+}
+
+// validSnapshotSize reports whether a filter, exact size if exact, can have
+// size slots.
+func validSnapshotSize(size uint64, exact bool) bool {
+	return size != 0 && size <= maxFilterSize && (exact || size&(size-1) == 0)
+}
+
+// restore makes array the filter's slots, giving the filter a default hash
+// and clock if it has none.
+func (f *Filter) restore(array []atomic.Pointer[[]byte], occupied int64) {
+	if f.newHash == nil {
+		f.newHash = newMD5UintHash
+		f.hashes = newHashPool(f.newHash)
+		f.hashName = "md5"
+	}
+	if f.now == nil {
+		f.now = time.Now
+	}
+	t := &table{slots: array, mask: uint64(len(array)) - 1, exact: f.exact}
+	if f.collisions {
+		t.collisions = make([]atomic.Uint32, len(array))
+	}
+	f.table.Store(t)
+	f.occupied.Store(occupied)
+}
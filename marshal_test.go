@@ -0,0 +1,215 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMarshalRoundTrip(t *testing.T) {
+	f, _ := NewFilter(256)
+	ids := make([][]byte, 50)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 0xff, byte(i * 7)}
+		f.Contains(ids[i])
+	}
+	f.Contains([]byte{})
+	f.Forget(ids[0])
+
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var g Filter
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g.Size() != f.Size() {
+		t.Errorf("size should be %d, got: %d", f.Size(), g.Size())
+	}
+	if g.Count() != f.Count() {
+		t.Errorf("count should be %d, got: %d", f.Count(), g.Count())
+	}
+	for _, id := range append(ids, []byte{}) {
+		if f.Peek(id) != g.Peek(id) {
+			t.Errorf("restored filter disagrees on %v", id)
+		}
+	}
+}
+
+func TestUnmarshalInvalid(t *testing.T) {
+	f, _ := NewFilter(4)
+	f.Contains([]byte{1, 2, 3})
+	data, _ := f.MarshalBinary()
+	for _, bad := range [][]byte{
+		nil,
+		{0},
+		{snapshotVersion},
+		{snapshotVersion, 3},
+		{snapshotVersion, 4, 4, 0},
+		data[:len(data)-1],
+	} {
+		var g Filter
+		if err := g.UnmarshalBinary(bad); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Errorf("UnmarshalBinary(%v) should fail with ErrInvalidSnapshot, got: %v", bad, err)
+		}
+	}
+}
+
+func TestUnmarshalIndexOutOfRange(t *testing.T) {
+	f, _ := NewFilter(4)
+	f.Contains([]byte{1, 2, 3})
+	for _, c := range []struct {
+		data []byte
+		msg  string
+	}{
+		{[]byte{snapshotVersion, 4, 4, 1, 'a'}, "oppobloom: invalid snapshot: index 4 out of range for 4 slots"},
+		{[]byte{snapshotVersion, 4, 1, 1, 'a', 0xff, 0xff, 0x03, 1, 'b'}, "oppobloom: invalid snapshot: index 65535 out of range for 4 slots"},
+		{[]byte(`{"size":4,"entries":[{"index":9,"id":"YQ=="}]}`), "oppobloom: invalid snapshot: index 9 out of range for 4 slots"},
+	} {
+		var err error
+		if c.data[0] == '{' {
+			err = f.UnmarshalJSON(c.data)
+		} else {
+			err = f.UnmarshalBinary(c.data)
+		}
+		if !errors.Is(err, ErrInvalidSnapshot) || err.Error() != c.msg {
+			t.Errorf("snapshot %q should fail with %q, got: %v", c.data, c.msg, err)
+		}
+		if !f.Peek([]byte{1, 2, 3}) || f.Count() != 1 {
+			t.Errorf("failed unmarshal should leave the filter unchanged")
+		}
+	}
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	f, _ := NewFilter(256)
+	ids := make([][]byte, 50)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 0xfd, byte(i * 5)}
+		f.Contains(ids[i])
+	}
+	f.Forget(ids[0])
+
+	// Filters are sent as fields of RPC arguments.
+	type args struct {
+		Name   string
+		Filter *Filter
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(args{"seen", f}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got args
+	if err := gob.NewDecoder(&buf).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g := got.Filter
+	if got.Name != "seen" || g.Size() != f.Size() || g.Count() != f.Count() {
+		t.Errorf("decoded filter should have size %d and count %d, got: %d and %d", f.Size(), f.Count(), g.Size(), g.Count())
+	}
+	for _, id := range append(ids, []byte{1}) {
+		if f.Contains(id) != g.Contains(id) {
+			t.Errorf("decoded filter disagrees on %v", id)
+		}
+	}
+	if err := new(Filter).GobDecode([]byte{0}); err != ErrInvalidSnapshot {
+		t.Errorf("invalid gob data should fail with ErrInvalidSnapshot, got: %v", err)
+	}
+}
+
+func TestMarshalJSONRoundTrip(t *testing.T) {
+	f, _ := NewFilter(256)
+	ids := make([][]byte, 50)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 0xff, 0xfe, byte(i * 7)}
+		f.Contains(ids[i])
+	}
+	f.Contains([]byte{})
+	f.Forget(ids[0])
+
+	data, err := json.Marshal(f)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var g Filter
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g.Size() != f.Size() || g.Count() != f.Count() {
+		t.Errorf("restored filter should have size %d and count %d, got: %d and %d", f.Size(), f.Count(), g.Size(), g.Count())
+	}
+	for _, id := range append(ids, []byte{}) {
+		if f.Peek(id) != g.Peek(id) {
+			t.Errorf("restored filter disagrees on %v", id)
+		}
+		if f.Contains(id) != g.Contains(id) {
+			t.Errorf("restored filter disagrees on %v", id)
+		}
+	}
+}
+
+func TestMarshalJSONFormat(t *testing.T) {
+	f, _ := NewFilter(2)
+	data, _ := json.Marshal(f)
+	if string(data) != `{"size":2,"entries":[]}` {
+		t.Errorf("empty filter marshaled to: %s", data)
+	}
+	f.Contains([]byte{0xff})
+	data, _ = json.Marshal(f)
+	want := fmt.Sprintf(`{"size":2,"entries":[{"index":%d,"id":"/w=="}]}`, f.caculateIndex([]byte{0xff}))
+	if string(data) != want {
+		t.Errorf("filter should marshal to %s, got: %s", want, data)
+	}
+}
+
+func TestUnmarshalJSONInvalid(t *testing.T) {
+	for _, bad := range []string{
+		``,
+		`{"size":0,"entries":[]}`,
+		`{"size":3,"entries":[]}`,
+		`{"size":4,"entries":[{"index":4,"id":""}]}`,
+		`{"size":4,"entries":[{"index":0,"id":"!"}]}`,
+	} {
+		var g Filter
+		if err := g.UnmarshalJSON([]byte(bad)); err == nil {
+			t.Errorf("UnmarshalJSON(%s) should fail", bad)
+		}
+	}
+}
+
+func TestUnmarshalHugeSize(t *testing.T) {
+	huge := binary.AppendUvarint([]byte{snapshotVersion}, 1<<40)
+	var f Filter
+	if err := f.UnmarshalBinary(huge); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("snapshot of 2^40 slots should be rejected, got: %v", err)
+	}
+	if err := f.UnmarshalJSON([]byte(`{"size": 1099511627776, "entries": []}`)); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("JSON snapshot of 2^40 slots should be rejected, got: %v", err)
+	}
+
+	g, _ := NewFilter(1 << 21)
+	g.Contains([]byte("a"))
+	data, _ := g.MarshalBinary()
+	if err := f.UnmarshalBinary(data); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("snapshot larger than the default limit should be rejected, got: %v", err)
+	}
+	if err := f.UnmarshalBinaryLimit(data, 1<<21); err != nil || !f.Peek([]byte("a")) {
+		t.Errorf("snapshot within the given limit should load, got: %v", err)
+	}
+	// A filter loads snapshots up to its own size.
+	h, _ := NewFilter(1 << 21)
+	if err := h.UnmarshalBinary(data); err != nil {
+		t.Errorf("snapshot of the filter's size should load, got: %v", err)
+	}
+	if err := h.UnmarshalBinaryLimit(data, 1<<20); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("snapshot above the given limit should be rejected, got: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "encoding/binary"
+
+// A NamespacedFilter is a filter shared by many namespaces, such as tenants,
+// that each see only their own ids. Every id is stored prefixed with its
+// namespace, so an id in one namespace is a different id from the same bytes
+// in another and they only collide as often as any two ids. The namespaces
+// share the slots, so a busy namespace evicts the ids of the others.
+type NamespacedFilter struct {
+	filter *Filter
+}
+
+// NewNamespacedFilter returns a NamespacedFilter of at least size slots,
+// configured by opts like NewFilter.
+func NewNamespacedFilter(size int, opts ...Option) (*NamespacedFilter, error) {
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &NamespacedFilter{f}, nil
+}
+
+// Contains adds id to namespace ns and returns true if it was already there.
+func (n *NamespacedFilter) Contains(ns string, id []byte) bool {
+	return n.filter.Contains(namespaced(ns, id))
+}
+
+// Peek returns true if id is in namespace ns without adding it.
+func (n *NamespacedFilter) Peek(ns string, id []byte) bool {
+	return n.filter.Peek(namespaced(ns, id))
+}
+
+// Forget removes id from namespace ns.
+func (n *NamespacedFilter) Forget(ns string, id []byte) {
+	n.filter.Forget(namespaced(ns, id))
+}
+
+// Size returns the number of slots shared by the namespaces.
+func (n *NamespacedFilter) Size() int {
+	return n.filter.Size()
+}
+
+// namespaced returns the id stored for id in ns: the length of ns, ns and
+// then id, so that no two pairs of namespace and id are stored alike.
+func namespaced(ns string, id []byte) []byte {
+	key := make([]byte, 0, binary.MaxVarintLen64+len(ns)+len(id))
+	key = binary.AppendUvarint(key, uint64(len(ns)))
+	key = append(key, ns...)
+	return append(key, id...)
+}
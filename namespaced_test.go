@@ -0,0 +1,37 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"testing"
+)
+
+func TestNamespacedFilter(t *testing.T) {
+	n, err := NewNamespacedFilter(1 << 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	id := []byte{27, 28, 29}
+	if n.Contains("a", id) {
+		t.Errorf("fresh id should not be contained in a")
+	}
+	if n.Peek("b", id) || n.Contains("b", id) {
+		t.Errorf("id seen in a should not be contained in b")
+	}
+	if !n.Contains("a", id) || !n.Contains("b", id) {
+		t.Errorf("id should be contained in both namespaces")
+	}
+	n.Forget("a", id)
+	if n.Peek("a", id) || !n.Peek("b", id) {
+		t.Errorf("forgetting id in a should leave it in b")
+	}
+	// Concatenating namespace and id would store these alike.
+	n.Contains("ab", []byte("c"))
+	if n.Peek("a", []byte("bc")) {
+		t.Errorf("pairs that concatenate alike should be different ids")
+	}
+	if n.Size() != 1<<16 {
+		t.Errorf("size should be %d, got: %d", 1<<16, n.Size())
+	}
+}
@@ -4,102 +4,1707 @@
 
 // Package oppobloom implements a filter data structure that may report false
 // negatives but no false positives.
+//
+// A filter stores the ids passed to it without copying them, so an id's buffer
+// must not be changed or reused afterwards. Ids that are sub-slices of a
+// reused buffer, such as the slices returned by bufio.Scanner.Bytes, must be
+// passed to ContainsCopy or to a filter made with WithCopyOnInsert instead.
 package oppobloom
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash"
 	"math"
+	"math/bits"
+	"runtime"
+	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 type Filter struct {
-	array          []*[]byte
-	sizeMask       uint32
-	forgetedUnsafe unsafe.Pointer
+	occupied  atomic.Int64 // slots holding an id
+	inserts   atomic.Uint64
+	hits      atomic.Uint64
+	evictions atomic.Uint64
+	forgets   atomic.Uint64
+	misses    atomic.Uint64 // forgets that did not find their id
+	limited   atomic.Uint64 // forgets dropped by WithForgetRateLimit
+	retries   atomic.Uint32 // most compare-and-swaps an insert failed
+	table     atomic.Pointer[table]
+	grow      sync.Mutex // serializes Grow
+	requested int        // size passed to the constructor, for WithExactSize
+	onEvict   atomic.Pointer[func(evicted, inserted []byte)]
+	uint64s   atomic.Bool   // ContainsUint64 was called, for storedSum
+	samples   atomic.Uint64 // ids sampled with WithSampleRate so far
+
+	// evictionLog is made on the first eviction, with WithEvictionLog.
+	evictionLog atomic.Pointer[evictionLog]
+
+	// auditLog is set by WithAuditLog, and thresholds by
+	// WithLoadThresholds.
+	auditLog   *auditLog
+	thresholds *loadThresholds
+
+	// forgetCount ids were forgotten in forgetSecond of the filter's clock,
+	// for WithForgetRateLimit.
+	forgetSecond atomic.Int64
+	forgetCount  atomic.Int64
+	config
+}
+
+// table holds a filter's slots along with their mask, so that Grow can
+// replace both at once.
+type table struct {
+	slots      []atomic.Pointer[[]byte]
+	mask       uint64                          // len(slots)-1
+	exact      bool                            // reduce sums modulo len(slots) rather than with mask
+	collisions []atomic.Uint32                 // evictions per slot, with collision stats
+	priorities atomic.Pointer[[]atomic.Uint32] // made by ContainsPriority
+}
+
+// config is how a filter was built, which filters made from it share.
+type config struct {
+	newHash         func() hash.Hash32
+	hashName        string // of newHash, for Config
+	hashes          *sync.Pool
+	seed            []byte
+	maxLoad         float64
+	fingerprint     bool
+	now             func() time.Time
+	copyOnInsert    bool
+	collisions      bool
+	observer        func(op string, d time.Duration)
+	index           func(id []byte) int // replaces the hash, for tests
+	autoGrow        float64
+	maxForgets      int64 // per second, with WithForgetRateLimit
+	skipRedundant   bool
+	evictionLogSize int              // with WithEvictionLog
+	order           binary.ByteOrder // of digest words, little-endian if nil
+	maxCASRetries   int              // by ContainsE, unbounded if 0
+	adaptive        bool             // mix short ids with shortSum instead of hashing them
+	exact           bool             // size is not rounded, with NewExactSizeFilter
+	interned        *sync.Map        // from string(id) to its shared copy, with WithInterning
+	rejectEmpty     bool
+	skipRate        float64 // of the inserts of Contains, with WithSampleRate
+	sampleSeed      uint64  // of the picks of WithSampleRate
+	indexBits       int     // of hashes used to index ids, all 64 if 0
 }
 
 var ErrSizeTooLarge = errors.New("oppobloom: size given too large to round to a power of 2")
 var ErrSizeTooSmall = errors.New("oppobloom: filter cannot have a zero or negative size")
-var MaxFilterSize = 1 << 30
+var ErrNilHash = errors.New("oppobloom: hash function cannot be nil")
+var ErrHashUnavailable = errors.New("oppobloom: hash function is unavailable")
+var ErrFilterSaturated = errors.New("oppobloom: filter is loaded beyond its maximum load factor")
+var ErrEmptyID = errors.New("oppobloom: id cannot be nil or empty")
+var ErrInvalidRate = errors.New("oppobloom: rate must be between 0 and 1")
+var ErrCorruptFilter = errors.New("oppobloom: filter is corrupt")
+var ErrContention = errors.New("oppobloom: slot changed too often to be updated")
+var ErrInvalidCount = errors.New("oppobloom: count must be between 0 and the size")
+var ErrInvalidSum = errors.New("oppobloom: sum is not a digest of the filter's hash")
+var ErrSeededSum = errors.New("oppobloom: seeded filters cannot use precomputed sums")
+var ErrSizeNotSmaller = errors.New("oppobloom: size given is not smaller than the filter")
+var ErrHashMismatch = errors.New("oppobloom: filters index ids differently")
+
+// forgeted marks slots whose id was forgotten. It is compared by identity and
+// no id passed to the filter is stored at its address, so even an empty id is
+// never mistaken for it. It is shared by all filters, so slots copied between
+// filters stay forgotten.
+var forgeted = new([]byte)
+
+// maxFilterSize is the largest filter the platform supports: 2^40 on 64-bit
+// platforms and 2^28 on 32-bit ones, where the 4 byte slots of a larger filter
+// would take more than math.MaxInt bytes and make would panic. Sizes checked
+// against it are never rounded up past what int can hold.
+const maxFilterSize = 1 << (28 + (bits.UintSize-32)*12/32)
+
+// MaxFilterSize is the largest size NewFilter accepts.
+//
+// Deprecated: NewFilter no longer reads MaxFilterSize, so changing it has no
+// effect. Use NewFilterCapped to limit the size of a filter.
+var MaxFilterSize = maxFilterSize
+
+// NewFilter returns a filter of at least size slots that indexes ids with MD5.
+func NewFilter(size int, opts ...Option) (*Filter, error) {
+	return NewFilterWithHash(size, newMD5UintHash, opts...)
+}
+
+// NewExactSizeFilter is NewFilter but keeps size as it is instead of rounding
+// it up to a power of two, which can almost halve the memory taken by sizes
+// just above one, such as 1025. In exchange, slots are found by reducing hashes
+// modulo size rather than masking them, which is somewhat slower, and the
+// filter's snapshots can only be unmarshaled into other exact size filters.
+// Grow still doubles the size.
+func NewExactSizeFilter(size int, opts ...Option) (*Filter, error) {
+	return newFilter(size, maxFilterSize, true, newMD5UintHash, opts)
+}
+
+// NewFilterCapped is NewFilter but returns ErrSizeTooLarge if size, once
+// rounded up to a power of two, is larger than maxSize.
+func NewFilterCapped(size, maxSize int, opts ...Option) (*Filter, error) {
+	return newFilter(size, maxSize, false, newMD5UintHash, opts)
+}
+
+// NewFilterWithHash returns a filter of at least size slots that indexes ids
+// with the hash returned by h, e.g. fnv.New32a or crc32.NewIEEE. Hashes are
+// pooled and reused across lookups.
+func NewFilterWithHash(size int, h func() hash.Hash32, opts ...Option) (*Filter, error) {
+	return newFilter(size, maxFilterSize, false, h, opts)
+}
+
+// NewFilterWithHash64 is NewFilterWithHash for a 64-bit hash such as
+// fnv.New64a, whose full Sum64 is masked to index ids.
+func NewFilterWithHash64(size int, h func() hash.Hash64, opts ...Option) (*Filter, error) {
+	if h == nil {
+		return nil, ErrNilHash
+	}
+	f, err := newFilter(size, maxFilterSize, false, func() hash.Hash32 { return hash64{h()} }, opts)
+	if err != nil {
+		return nil, err
+	}
+	f.hashName = nameHash(hash64Names, h)
+	return f, nil
+}
+
+// NewFilterWithSeed is NewFilter but mixes seed into the hash of every id, so
+// that ids crafted to collide in one filter are spread out in filters with
+// other seeds. A seed of 0 is the same as NewFilter.
+func NewFilterWithSeed(size int, seed uint64, opts ...Option) (*Filter, error) {
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if seed != 0 {
+		f.seed = binary.LittleEndian.AppendUint64(nil, seed)
+	}
+	return f, nil
+}
+
+// NewFilterFrom returns a filter of the given size holding ids, added in order
+// with a single hash. An id may be evicted by a later colliding one.
+func NewFilterFrom(size int, ids [][]byte, opts ...Option) (*Filter, error) {
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	h := f.hashes.Get().(hash.Hash32)
+	defer f.hashes.Put(h)
+	for _, id := range ids {
+		id = f.key(id)
+		f.sampledAt(f.caculateIndexWith(h, id), id)
+	}
+	return f, nil
+}
+
+// NewFilterFromParallel is NewFilterFrom but adds ids from workers
+// goroutines, or GOMAXPROCS of them if workers is not positive, to build
+// filters with large seed sets faster. The goroutines race on colliding ids,
+// so unlike with NewFilterFrom which of them the filter ends up holding is
+// nondeterministic.
+func NewFilterFromParallel(size int, ids [][]byte, workers int, opts ...Option) (*Filter, error) {
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	parallel(len(ids), workers, func(start, end int) {
+		h := f.hashes.Get().(hash.Hash32)
+		defer f.hashes.Put(h)
+		for _, id := range ids[start:end] {
+			id = f.key(id)
+			f.sampledAt(f.caculateIndexWith(h, id), id)
+		}
+	})
+	return f, nil
+}
+
+// NewFilterForCapacity returns a filter for n distinct ids, sized so that
+// they fill at most maxLoad of its slots: RoundedSize(ceil(n/maxLoad)) slots,
+// e.g. 2048 for 1000 ids at a maxLoad of 0.5. maxLoad must be greater than 0
+// and at most 1, or ErrInvalidLoadFactor is returned.
+func NewFilterForCapacity(n int, maxLoad float64, opts ...Option) (*Filter, error) {
+	if !(maxLoad > 0 && maxLoad <= 1) {
+		return nil, ErrInvalidLoadFactor
+	}
+	if n <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	size := math.Ceil(float64(n) / maxLoad)
+	if size > maxFilterSize {
+		return nil, ErrSizeTooLarge
+	}
+	return NewFilter(int(size), opts...)
+}
+
+// NewFilterForRate returns a filter of the size RecommendSize returns for
+// expectedItems and maxFalseNegativeRate, along with its errors, such as
+// ErrSizeTooLarge if no filter is large enough.
+func NewFilterForRate(expectedItems int, maxFalseNegativeRate float64, opts ...Option) (*Filter, error) {
+	size, err := RecommendSize(expectedItems, maxFalseNegativeRate)
+	if err != nil {
+		return nil, err
+	}
+	return NewFilter(size, opts...)
+}
+
+// RoundedSize returns the number of slots in a filter made with NewFilter(size),
+// which is size rounded up to the next power of two, or 0 if NewFilter would
+// reject size.
+func RoundedSize(size int) int {
+	if size <= 0 || size > maxFilterSize {
+		return 0
+	}
+	// round to the next largest power of two with integer math, since the
+	// float64 logarithm can be off by one near powers of two
+	return 1 << bits.Len64(uint64(size-1))
+}
+
+// RecommendSize returns the smallest size for which FalseNegativeRate is
+// expected to be at most maxFalseNegativeRate once expectedItems distinct ids
+// were added. After n ids, each of size slots is empty with probability
+// (1-1/size)^n, so the expected rate is 1-(1-1/size)^n.
+func RecommendSize(expectedItems int, maxFalseNegativeRate float64) (int, error) {
+	if expectedItems <= 0 {
+		return 0, ErrSizeTooSmall
+	}
+	if !(maxFalseNegativeRate > 0 && maxFalseNegativeRate < 1) {
+		return 0, ErrInvalidRate
+	}
+	// size stops at maxFilterSize, as doubling it could overflow int.
+	for size := 1; ; size *= 2 {
+		if expectedFalseNegativeRate(expectedItems, size) <= maxFalseNegativeRate {
+			return size, nil
+		}
+		if size == maxFilterSize {
+			return 0, ErrSizeTooLarge
+		}
+	}
+}
 
-func NewFilter(size int) (*Filter, error) {
-	if size > MaxFilterSize {
+// CollisionProbability returns the probability that a new id falls in an
+// occupied slot of a filter of size slots after n distinct ids were added to
+// it, 1-(1-1/size)^n as for RecommendSize, without needing a filter. size must
+// be positive and n between 0 and size.
+func CollisionProbability(n, size int) (float64, error) {
+	if size <= 0 {
+		return 0, ErrSizeTooSmall
+	}
+	if n < 0 || n > size {
+		return 0, ErrInvalidCount
+	}
+	return expectedFalseNegativeRate(n, size), nil
+}
+
+func expectedFalseNegativeRate(items, size int) float64 {
+	return -math.Expm1(float64(items) * math.Log1p(-1/float64(size)))
+}
+
+func newFilter(size, maxSize int, exact bool, h func() hash.Hash32, opts []Option) (*Filter, error) {
+	if h == nil {
+		return nil, ErrNilHash
+	}
+	probed, err := probeHash(h)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > maxFilterSize {
+		maxSize = maxFilterSize
+	}
+	if size > maxSize {
 		return nil, ErrSizeTooLarge
 	}
 	if size <= 0 {
 		return nil, ErrSizeTooSmall
 	}
-	// round to the next largest power of two
-	size = int(math.Pow(2, math.Ceil(math.Log2(float64(size)))))
-	slice := make([]*[]byte, size)
-	sizeMask := uint32(size - 1)
+	requested := size
+	if !exact {
+		size = RoundedSize(size)
+		if size > maxSize {
+			return nil, ErrSizeTooLarge
+		}
+		if size&(size-1) != 0 {
+			panic("oppobloom: rounded size is not a power of two")
+		}
+	}
+	f := &Filter{
+		requested: requested,
+		config: config{
+			newHash:  h,
+			hashName: nameHash(hashNames, h),
+			hashes:   newHashPool(h),
+			now:      time.Now,
+			exact:    exact,
+		},
+	}
+	f.table.Store(f.newTable(size))
+	f.hashes.Put(probed)
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (f *Filter) newTable(size int) *table {
+	t := &table{slots: make([]atomic.Pointer[[]byte], size), mask: uint64(size - 1), exact: f.exact}
+	if f.collisions {
+		t.collisions = make([]atomic.Uint32, size)
+	}
+	return t
+}
+
+// reduce returns the index of the slot of t that sum, a hash of an id, maps
+// to. An index is reduced to itself, so it can be reduced again to be safe.
+func (t *table) reduce(sum uint64) uint64 {
+	if t.exact {
+		return sum % uint64(len(t.slots))
+	}
+	return sum & t.mask
+}
 
-	forgetedHolder := []byte{}
-	return &Filter{slice, sizeMask, unsafe.Pointer(&forgetedHolder)}, nil
+// item returns the slot at index in the filter's current table. index is
+// reduced again in case Grow replaced the table after it was computed, which
+// can only put an id in the wrong slot, where it is not found.
+func (f *Filter) item(index uint64) *atomic.Pointer[[]byte] {
+	t := f.table.Load()
+	return &t.slots[t.reduce(index)]
 }
 
 // Contains adds id to the hashmap and then returns true if id already exist.
+// A nil id and an empty one are the same id, which is stored like any other.
+// id itself is stored, not a copy, so it must not be changed afterwards.
 func (f *Filter) Contains(id []byte) bool {
-	oldId := getAndSet(f.array, f.caculateIndex(id), id)
-	return bytes.Equal(oldId, id)
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	id = f.key(id)
+	return f.sampledAt(f.caculateIndex(id), id)
 }
 
-// Forget removes id if it in the filter.
+// ContainsOwned is Contains for an id whose buffer the caller hands over to
+// the filter, e.g. one taken from a pool: if it returns false, id is stored as
+// is, even with WithCopyOnInsert or WithInterning, and the caller must never
+// change or reuse it again. If it returns true, id was already in the filter,
+// which keeps its own buffer, and the caller still owns id. A buffer handed
+// over is given back to an OnEvict callback as the evicted id once a
+// different id takes its slot, so that it can be returned to its pool.
+// Buffers dropped otherwise, by Forget and Reset or when another goroutine
+// stores the same id at the same time, are left to the garbage collector. A
+// fingerprint filter stores a digest instead, so it never keeps id.
+func (f *Filter) ContainsOwned(id []byte) bool {
+	if f.fingerprint {
+		return f.Contains(id)
+	}
+	if f.rejectEmpty && len(id) == 0 {
+		return false
+	}
+	t := f.table.Load()
+	index := t.reduce(f.caculateIndex(id))
+	if f.hit(t, index, id) {
+		return true
+	}
+	present, _, _ := f.tryStore(t, index, id, nil)
+	return present
+}
+
+// ContainsScratch is ContainsCopy for a scratch buffer that the caller
+// reuses for every id. buf is copied only if it is stored, so looking up an
+// id that is already in its slot doesn't allocate.
+func (f *Filter) ContainsScratch(buf []byte) bool {
+	id := f.key(buf)
+	t := f.table.Load()
+	index := t.reduce(f.caculateIndex(id))
+	if f.hit(t, index, id) {
+		return true
+	}
+	if !f.fingerprint && !f.copyOnInsert && f.interned == nil {
+		id = bytes.Clone(id)
+	}
+	return f.containsAt(index, id)
+}
+
+// NotContains adds id to the filter and returns true if id was not in its
+// slot, the negation of Contains. A false result is authoritative: id was
+// added before. A true result is not, since id may have been added and then
+// evicted by a colliding id, so it may be a repeat that is reported as new.
+func (f *Filter) NotContains(id []byte) bool {
+	return !f.Contains(id)
+}
+
+// ContainsCopy is Contains but stores a copy of id, so the caller may reuse
+// id's buffer afterwards.
+func (f *Filter) ContainsCopy(id []byte) bool {
+	id = f.key(id)
+	if !f.fingerprint && !f.copyOnInsert && f.interned == nil {
+		id = bytes.Clone(id)
+	}
+	return f.containsAt(f.caculateIndex(id), id)
+}
+
+// ContainsHashed is Contains for an id whose index the caller computed, e.g.
+// with its own hash in a pass over a batch. index is masked to the filter's
+// size, or reduced modulo it in an exact size filter, so that any index is in
+// range, and id is stored as given, even in a fingerprint filter. An id added
+// with ContainsHashed is only found by Contains if index agrees with the
+// filter's hash.
+func (f *Filter) ContainsHashed(index uint64, id []byte) bool {
+	return f.containsAt(index, id)
+}
+
+// ContainsWithHash is Contains but also returns the unmasked hash of id that
+// its slot was found with, so that it can be reused, e.g. to route id to a
+// shard, instead of hashing id again. It is the same for an id in every filter
+// with the same hash and seed, whatever their sizes, and the slot's index is
+// the hash masked to the filter's size, as ContainsHashed takes it.
+func (f *Filter) ContainsWithHash(id []byte) (present bool, hash uint64) {
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	id = f.key(id)
+	hash = f.caculateHash(id)
+	return f.containsAt(hash, id), hash
+}
+
+// ContainsPrehashed is Contains for an id whose unseeded digest sum the caller
+// already made with the filter's hash, e.g. md5.Sum(id) for a filter made with
+// NewFilter, so that the filter need not hash id again. sum must be as long as
+// the hash's digests, or ErrInvalidSum is returned, and seeded filters return
+// ErrSeededSum, since their digests depend on the seed. A fingerprint filter
+// stores sum, its fingerprint of id. An id is only found by Contains if sum
+// really is its digest.
+func (f *Filter) ContainsPrehashed(sum, id []byte) (bool, error) {
+	if f.seed != nil {
+		return false, ErrSeededSum
+	}
+	h := f.hashes.Get().(hash.Hash32)
+	index, ok := prehashedSum(h, sum)
+	f.hashes.Put(h)
+	if !ok {
+		return false, ErrInvalidSum
+	}
+	index = f.narrow(index)
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	if f.fingerprint {
+		id = sum
+	}
+	if f.fingerprint || f.index != nil || f.adaptive && len(id) <= maxShortID {
+		// These don't hash id, so sum is of no help.
+		index = f.caculateHash(id)
+	}
+	return f.containsAt(index, id), nil
+}
+
+// prehashedSum returns what sum64 would for h after writing an id whose
+// digest is sum. Other than MD5, whose digest is folded, hashes are assumed to
+// encode their sums in big-endian order, as those of the standard library do.
+func prehashedSum(h hash.Hash32, sum []byte) (uint64, bool) {
+	if len(sum) != h.Size() {
+		return 0, false
+	}
+	if m, ok := h.(*md5UintHash); ok {
+		return foldDigest64(m.order, sum), true
+	}
+	switch len(sum) {
+	case 8:
+		return binary.BigEndian.Uint64(sum), true
+	case 4:
+		return uint64(binary.BigEndian.Uint32(sum)), true
+	}
+	return 0, false
+}
+
+// ContainsE is Contains but returns ErrEmptyID without touching the filter if
+// id is nil or empty. With WithMaxCASRetries, it also returns ErrContention
+// without storing id if other goroutines kept changing id's slot.
+func (f *Filter) ContainsE(id []byte) (bool, error) {
+	if len(id) == 0 {
+		return false, ErrEmptyID
+	}
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	return f.containsE(f.key(id), f.casGiveUp())
+}
+
+// containsE is ContainsE for id as the filter stores it, giving up when
+// giveUp does.
+func (f *Filter) containsE(id []byte, giveUp func(failed int) bool) (bool, error) {
+	present, _, swapped := f.tryInsert(f.caculateIndex(id), id, giveUp)
+	if !swapped {
+		return false, ErrContention
+	}
+	return present, nil
+}
+
+// casGiveUp returns the giveUp of tryInsert for WithMaxCASRetries, or nil.
+func (f *Filter) casGiveUp() func(failed int) bool {
+	if f.maxCASRetries == 0 {
+		return nil
+	}
+	return func(failed int) bool { return failed >= f.maxCASRetries }
+}
+
+// ContainsTimeout is Contains but gives up, returning
+// context.DeadlineExceeded without storing id, if other goroutines kept
+// changing id's slot for longer than d, as told by the filter's clock. The
+// clock is read before every attempt to store id. With a d of 0 or less it
+// never gives up, like Contains.
+func (f *Filter) ContainsTimeout(id []byte, d time.Duration) (bool, error) {
+	if d <= 0 {
+		return f.Contains(id), nil
+	}
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	id = f.key(id)
+	index := f.caculateIndex(id)
+	deadline := f.now().Add(d)
+	present, _, swapped := f.tryInsert(index, id, func(int) bool { return f.now().After(deadline) })
+	if !swapped {
+		return false, context.DeadlineExceeded
+	}
+	return present, nil
+}
+
+// Add adds id to the filter. It is identical to Contains except that it
+// doesn't report whether id was already in the filter.
+func (f *Filter) Add(id []byte) {
+	f.Contains(id)
+}
+
+// ContainsAll calls Contains on each of ids in order and returns the results
+// in the same order, so an id repeated in ids is reported as contained from
+// its second occurrence on. A single hash is used for the whole batch.
+func (f *Filter) ContainsAll(ids [][]byte) []bool {
+	if f.observer != nil {
+		defer f.observe("contains_all", f.now())
+	}
+	h := f.hashes.Get().(hash.Hash32)
+	defer f.hashes.Put(h)
+	present := make([]bool, len(ids))
+	for i, id := range ids {
+		id = f.key(id)
+		present[i] = f.sampledAt(f.caculateIndexWith(h, id), id)
+	}
+	return present
+}
+
+// ContainsAllParallel is ContainsAll but splits ids between workers
+// goroutines, or GOMAXPROCS of them if workers is not positive. Results are in
+// the same order as ids, but the goroutines race on ids repeated in the batch,
+// so which occurrences of them are reported as contained is nondeterministic.
+func (f *Filter) ContainsAllParallel(ids [][]byte, workers int) []bool {
+	if f.observer != nil {
+		defer f.observe("contains_all_parallel", f.now())
+	}
+	present := make([]bool, len(ids))
+	parallel(len(ids), workers, func(start, end int) {
+		h := f.hashes.Get().(hash.Hash32)
+		defer f.hashes.Put(h)
+		for i := start; i < end; i++ {
+			id := f.key(ids[i])
+			present[i] = f.sampledAt(f.caculateIndexWith(h, id), id)
+		}
+	})
+	return present
+}
+
+// parallel splits [0, n) into a chunk for each of workers goroutines, or
+// GOMAXPROCS of them if workers is not positive, calls fn with the bounds of
+// each chunk on its goroutine and waits for them.
+func parallel(n, workers int, fn func(start, end int)) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	chunk := (n + workers - 1) / workers
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += chunk {
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			fn(start, end)
+		}(start, min(start+chunk, n))
+	}
+	wg.Wait()
+}
+
+// ContainsCtx is Contains but returns ctx's error if it is done, and
+// ErrFilterSaturated without inserting id if the filter was built with
+// WithMaxLoadFactor and is loaded beyond it. Without that option it behaves
+// exactly like Contains for a live context.
+func (f *Filter) ContainsCtx(ctx context.Context, id []byte) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	if f.maxLoad > 0 && float64(f.occupied.Load()) > f.maxLoad*float64(f.Size()) {
+		return false, ErrFilterSaturated
+	}
+	return f.Contains(id), nil
+}
+
+// FirstSeen adds id to the filter and calls onFirst with id if it was not
+// already in it, e.g. to emit an event for each new id. Of goroutines adding
+// the same new id at once, only the one that stores it calls onFirst, but
+// like any new id, an id evicted since it was last added calls it again.
+func (f *Filter) FirstSeen(id []byte, onFirst func(id []byte)) {
+	if !f.Contains(id) {
+		onFirst(id)
+	}
+}
+
+// ContainsOrLoad returns true if id is in the filter, and otherwise calls
+// load with id, e.g. to process it downstream, then adds id only if load
+// succeeded, so that an id whose processing failed is not seen as a
+// duplicate when it is retried. load's error is returned as is. id is not
+// held while load runs, so goroutines looking up the same new id at once may
+// all call load; if another one adds id meanwhile, ContainsOrLoad returns
+// true after its own load.
+func (f *Filter) ContainsOrLoad(id []byte, load func(id []byte) error) (bool, error) {
+	if f.observer != nil {
+		defer f.observe("contains_or_load", f.now())
+	}
+	key := f.key(id)
+	index := f.caculateIndex(key)
+	if f.peekAt(index, key) {
+		return true, nil
+	}
+	if err := load(id); err != nil {
+		return false, err
+	}
+	return f.sampledAt(index, key), nil
+}
+
+// sampledAt is containsAt for the methods that honour WithSampleRate, which
+// look id up instead of inserting it unless it is picked.
+func (f *Filter) sampledAt(index uint64, id []byte) bool {
+	if f.skipped() {
+		return f.peekAt(index, id)
+	}
+	return f.containsAt(index, id)
+}
+
+// skipped returns true if the next id is not picked by WithSampleRate. The
+// nth id is picked if the nth output of a SplitMix64 generator seeded with
+// f.sampleSeed falls within the rate.
+func (f *Filter) skipped() bool {
+	if f.skipRate == 0 {
+		return false
+	}
+	n := f.samples.Add(1)
+	x := splitmix64(f.sampleSeed + n*0x9e3779b97f4a7c15)
+	return float64(x>>11)/(1<<53) < f.skipRate
+}
+
+func (f *Filter) containsAt(index uint64, id []byte) bool {
+	present, _ := f.insert(index, id)
+	return present
+}
+
+// insert puts id in the slot at index with a compare-and-swap, keeping count
+// of the occupied slots and the stats, and calling the OnEvict callback. It
+// returns whether the slot already held id, or else the different id it
+// evicted, if any.
+func (f *Filter) insert(index uint64, id []byte) (present bool, evicted *[]byte) {
+	present, evicted, _ = f.tryInsert(index, id, nil)
+	return present, evicted
+}
+
+// tryInsert is insert but gives up, with swapped false and without storing
+// id, when tryGetAndSet does.
+func (f *Filter) tryInsert(index uint64, id []byte, giveUp func(failed int) bool) (present bool, evicted *[]byte, swapped bool) {
+	if f.rejectEmpty && len(id) == 0 {
+		return false, nil, true
+	}
+	t := f.table.Load()
+	index = t.reduce(index)
+	if f.skipRedundant && f.hit(t, index, id) {
+		return true, nil, true
+	}
+	return f.tryStore(t, index, f.storable(id), giveUp)
+}
+
+// tryStore is tryInsert once id is ready to be stored at index of t.
+func (f *Filter) tryStore(t *table, index uint64, id []byte, giveUp func(failed int) bool) (present bool, evicted *[]byte, swapped bool) {
+	oldId, ok, swapped, failed := tryGetAndSet(&t.slots[index], id, giveUp)
+	f.noteRetries(failed)
+	if !swapped {
+		return false, nil, false
+	}
+	t.setPriority(index, 0)
+	present, evicted = f.inserted(t, index, id, oldId, ok)
+	return present, evicted, true
+}
+
+// storable returns what to store for id: its shared copy with WithInterning,
+// a copy of it with WithCopyOnInsert, or else id itself.
+func (f *Filter) storable(id []byte) []byte {
+	switch {
+	case f.interned != nil:
+		if v, ok := f.interned.Load(string(id)); ok {
+			return v.([]byte)
+		}
+		v, _ := f.interned.LoadOrStore(string(id), bytes.Clone(id))
+		return v.([]byte)
+	case f.copyOnInsert:
+		return bytes.Clone(id)
+	}
+	return id
+}
+
+// hit returns true if the slot at index of t already holds id, counting it as
+// an insert that found id, without storing anything.
+func (f *Filter) hit(t *table, index uint64, id []byte) bool {
+	if p := t.slot(int(index)); p != nil && bytes.Equal(*p, id) {
+		t.setPriority(index, 0)
+		f.inserts.Add(1)
+		f.hits.Add(1)
+		return true
+	}
+	return false
+}
+
+// hitString is hit for a string id.
+func (f *Filter) hitString(t *table, index uint64, id string) bool {
+	if p := t.slot(int(index)); p != nil && string(*p) == id {
+		t.setPriority(index, 0)
+		f.inserts.Add(1)
+		f.hits.Add(1)
+		return true
+	}
+	return false
+}
+
+// inserted does the bookkeeping of insert after id was put in the slot at
+// index of t, where it replaced oldId, if ok.
+func (f *Filter) inserted(t *table, index uint64, id, oldId []byte, ok bool) (present bool, evicted *[]byte) {
+	f.inserts.Add(1)
+	switch {
+	case !ok:
+		occupied := f.occupied.Add(1)
+		if f.autoGrow > 0 && float64(occupied) > f.autoGrow*float64(len(t.slots)) {
+			f.tryGrow(t)
+		}
+		f.checkLoad(occupied)
+	case bytes.Equal(oldId, id):
+		f.hits.Add(1)
+		return true, nil
+	default:
+		f.audit(id)
+		f.evictions.Add(1)
+		if t.collisions != nil {
+			t.collisions[index].Add(1)
+		}
+		if cb := f.onEvict.Load(); cb != nil {
+			(*cb)(oldId, id)
+		}
+		if f.evictionLogSize > 0 {
+			f.logEviction(oldId, id)
+		}
+		// A copy, so that oldId only escapes on this path.
+		evicted := oldId
+		return false, &evicted
+	}
+	f.audit(id)
+	return false, nil
+}
+
+// Swap atomically puts replacement in the slot id hashes to and returns
+// whether that slot held id, along with the id it held. old is nil if the slot
+// was empty. replacement is stored at id's slot, so Contains and Peek only
+// find it there if it hashes to the same slot.
+func (f *Filter) Swap(id, replacement []byte) (oldPresent bool, old []byte) {
+	id, replacement = f.key(id), f.key(replacement)
+	replacement = f.storable(replacement)
+	t := f.table.Load()
+	index := t.reduce(f.caculateIndex(id))
+	old, ok, failed := getAndSet(&t.slots[index], replacement)
+	f.noteRetries(failed)
+	t.setPriority(index, 0)
+	if !ok || !bytes.Equal(old, replacement) {
+		f.audit(replacement)
+	}
+	if !ok {
+		f.occupied.Add(1)
+		return false, nil
+	}
+	return bytes.Equal(old, id), old
+}
+
+// observe reports the time since start of op to the WithObserver observer.
+func (f *Filter) observe(op string, start time.Time) {
+	f.observer(op, f.now().Sub(start))
+}
+
+// forgot updates the counts after a slot was swapped to the forgeted
+// sentinel.
+func (f *Filter) forgot() {
+	f.checkLoad(f.occupied.Add(-1))
+	f.forgets.Add(1)
+}
+
+// OnEvict makes the filter call cb whenever inserting an id evicts a
+// different one from its slot, replacing any previous callback. cb is called
+// on the inserting goroutine after the slot has been updated, so it does not
+// block other inserts, and must be safe for concurrent use. A nil cb removes
+// the callback. Callbacks are not copied by Clone or Resize.
+func (f *Filter) OnEvict(cb func(evicted, inserted []byte)) {
+	if cb == nil {
+		f.onEvict.Store(nil)
+		return
+	}
+	f.onEvict.Store(&cb)
+}
+
+// ContainsEvict is Contains but also returns the id that was evicted from
+// id's slot to make room for it. evicted is nil if the slot was empty or
+// already held id.
+func (f *Filter) ContainsEvict(id []byte) (present bool, evicted []byte) {
+	id = f.key(id)
+	present, evictedId := f.insert(f.caculateIndex(id), id)
+	if evictedId != nil {
+		return false, *evictedId
+	}
+	return present, nil
+}
+
+// ContainsEvicted is ContainsEvict but only reports whether a different id
+// was evicted, without returning it.
+func (f *Filter) ContainsEvicted(id []byte) (present bool, evicted bool) {
+	id = f.key(id)
+	present, evictedId := f.insert(f.caculateIndex(id), id)
+	return present, evictedId != nil
+}
+
+// A ContainsResult is everything Probe learned about an id.
+type ContainsResult struct {
+	Present bool   // as from Contains
+	Index   int    // of id's slot, as from ContainsWithIndex
+	Hash    uint64 // of id, unmasked, as from ContainsWithHash
+	Evicted []byte // the different id evicted, if any, as from ContainsEvict
+}
+
+// Probe is Contains but returns everything learned while inserting id, which
+// would otherwise take calls to several variants of Contains.
+func (f *Filter) Probe(id []byte) ContainsResult {
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	id = f.key(id)
+	hash := f.caculateHash(id)
+	index := f.reduce(hash)
+	present, evicted := f.insert(index, id)
+	result := ContainsResult{Present: present, Index: int(index), Hash: hash}
+	if evicted != nil {
+		result.Evicted = *evicted
+	}
+	return result
+}
+
+// ContainsWithIndex is Contains, inserting id in the same way, but also
+// returns the index of id's slot and the id the slot held before, which is nil
+// if the slot was empty. It is meant for debugging collisions.
+func (f *Filter) ContainsWithIndex(id []byte) (present bool, index int, occupant []byte) {
+	id = f.key(id)
+	i := f.caculateIndex(id)
+	present, evicted := f.insert(i, id)
+	switch {
+	case present:
+		occupant = id
+	case evicted != nil:
+		occupant = *evicted
+	}
+	return present, int(i), occupant
+}
+
+// Peek returns true if id is in the filter without adding it. Like Contains it
+// may report false for an id that was evicted by a colliding one, but unlike
+// Contains it never evicts anything.
+func (f *Filter) Peek(id []byte) bool {
+	id = f.key(id)
+	return f.peekAt(f.caculateIndex(id), id)
+}
+
+func (f *Filter) peekAt(index uint64, id []byte) bool {
+	p := f.slot(index)
+	return p != nil && bytes.Equal(*p, id)
+}
+
+// slot atomically loads the id in the slot at index, returning nil if the slot
+// is empty or holds the forgeted sentinel.
+func (f *Filter) slot(index uint64) *[]byte {
+	p := f.item(index).Load()
+	if p == forgeted {
+		return nil
+	}
+	return p
+}
+
+// Forget removes id if it in the filter. A slot holding any other id is left
+// untouched: Forget returns after a single load of it, without writing to it,
+// so forgetting absent ids doesn't contend with inserts of the ids present.
 func (f *Filter) Forget(id []byte) {
-	item := &f.array[f.caculateIndex(id)]
-	itemPtr := (*unsafe.Pointer)(unsafe.Pointer(item))
-	itemUnsafe := atomic.LoadPointer(itemPtr)
-	if bytes.Compare(**item, id) == 0 {
-		atomic.CompareAndSwapPointer(itemPtr, itemUnsafe, f.forgetedUnsafe)
+	if f.observer != nil {
+		defer f.observe("forget", f.now())
+	}
+	id = f.key(id)
+	f.forgetAt(f.caculateIndex(id), id)
+}
+
+// ForgetAll calls Forget on each of ids, using a single hash for the whole
+// batch.
+func (f *Filter) ForgetAll(ids [][]byte) {
+	if f.observer != nil {
+		defer f.observe("forget_all", f.now())
+	}
+	h := f.hashes.Get().(hash.Hash32)
+	defer f.hashes.Put(h)
+	for _, id := range ids {
+		id = f.key(id)
+		f.forgetAt(f.caculateIndexWith(h, id), id)
+	}
+}
+
+// ForgetReported is Forget but returns true only if this call removed id, and
+// false if id was not in the filter or another goroutine forgot it first.
+func (f *Filter) ForgetReported(id []byte) bool {
+	id = f.key(id)
+	return f.forgetAt(f.caculateIndex(id), id)
+}
+
+func (f *Filter) forgetAt(index uint64, id []byte) bool {
+	item := f.item(index)
+	allowed := f.maxForgets == 0
+	for {
+		old := item.Load()
+		if old == nil || old == forgeted || !bytes.Equal(*old, id) {
+			f.misses.Add(1)
+			return false
+		}
+		if !allowed {
+			if allowed = f.allowForget(); !allowed {
+				return false
+			}
+		}
+		if item.CompareAndSwap(old, forgeted) {
+			f.forgot()
+			return true
+		}
+	}
+}
+
+// ContainsString is Contains for a string id. The string is hashed without
+// conversion and is only copied into a []byte for storing in its slot, so
+// looking up an id that is already in its slot doesn't allocate.
+func (f *Filter) ContainsString(id string) bool {
+	if f.fingerprint {
+		return f.Contains([]byte(id))
+	}
+	t := f.table.Load()
+	index := t.reduce(f.caculateStringIndex(id))
+	if f.hitString(t, index, id) {
+		return true
+	}
+	present, _ := f.insert(index, []byte(id))
+	return present
+}
+
+// ContainsStringView is ContainsString without the copy of id when its slot
+// already holds it, so that looking up a stored id doesn't allocate. It reads
+// id's bytes in place through unsafe.StringData and copies them only to store
+// them, so id must not be changed, e.g. through unsafe code, until it returns.
+func (f *Filter) ContainsStringView(id string) bool {
+	return f.ContainsScratch(unsafe.Slice(unsafe.StringData(id), len(id)))
+}
+
+// ForgetString is Forget for a string id. It does not allocate.
+func (f *Filter) ForgetString(id string) {
+	if f.fingerprint {
+		f.Forget([]byte(id))
+		return
+	}
+	item := f.item(f.caculateStringIndex(id))
+	allowed := f.maxForgets == 0
+	for {
+		old := item.Load()
+		if old == nil || old == forgeted || string(*old) != id {
+			f.misses.Add(1)
+			return
+		}
+		if !allowed {
+			if allowed = f.allowForget(); !allowed {
+				return
+			}
+		}
+		if item.CompareAndSwap(old, forgeted) {
+			f.forgot()
+			return
+		}
+	}
+}
+
+// ContainsFold is ContainsString for ids compared without regard to case,
+// such as email addresses. id is folded with strings.ToLower, which maps each
+// rune by unicode.ToLower, and the folded form is what is stored, as Range and
+// Drain return it. Ids that only fold to the same form with special casing,
+// like "ß" and "SS", stay distinct.
+func (f *Filter) ContainsFold(id string) bool {
+	return f.ContainsString(strings.ToLower(id))
+}
+
+// ForgetFold is ForgetString for an id added by ContainsFold, removing it
+// whatever its case.
+func (f *Filter) ForgetFold(id string) {
+	f.ForgetString(strings.ToLower(id))
+}
+
+// Reset removes every id from the filter, reusing the underlying array. It is
+// safe to call concurrently with Contains and Forget, which will observe each
+// slot either as it was or as empty. Reset empties the slots one at a time, so
+// it only guarantees to remove the ids that were in the filter when it started
+// and not inserted again meanwhile: an id inserted while it runs may or may
+// not be left, depending on whether Reset had reached its slot yet. Either
+// way Count and IsEmpty agree with the slots once the inserts are done.
+func (f *Filter) Reset() {
+	t := f.table.Load()
+	for i := range t.slots {
+		p := t.slots[i].Swap(nil)
+		if p != nil && p != forgeted {
+			f.occupied.Add(-1)
+		}
+	}
+	f.checkLoad(f.occupied.Load())
+}
+
+// Drain forgets every id in the filter and returns them, in no particular
+// order. Each slot is emptied atomically, so every id is either returned by
+// Drain or left for a concurrent Forget, never both, and ids inserted while
+// Drain runs may or may not be drained.
+func (f *Filter) Drain() [][]byte {
+	var ids [][]byte
+	t := f.table.Load()
+	for i := range t.slots {
+		p := t.slots[i].Swap(forgeted)
+		if p != nil && p != forgeted {
+			ids = append(ids, *p)
+			f.forgot()
+		}
+	}
+	return ids
+}
+
+// Compact empties every slot holding the forgeted sentinel, which Forget leaves
+// behind. Forgotten and empty slots behave the same, so this only tidies the
+// slots. Each slot is compacted with a compare-and-swap, so a concurrent insert
+// is never undone.
+func (f *Filter) Compact() {
+	t := f.table.Load()
+	for i := range t.slots {
+		t.slots[i].CompareAndSwap(forgeted, nil)
+	}
+}
+
+// Clone returns a copy of the filter. Stored ids are never modified, so the
+// copy shares them with f, but changes to either filter don't affect the
+// other.
+func (f *Filter) Clone() *Filter {
+	t := f.table.Load()
+	g := &Filter{config: f.config}
+	g.uint64s.Store(f.uint64s.Load())
+	gt := g.newTable(len(t.slots))
+	g.table.Store(gt)
+	for i := range t.slots {
+		p := t.slots[i].Load()
+		gt.slots[i].Store(p)
+		gt.setPriority(uint64(i), t.priority(uint64(i)))
+		if p != nil && p != forgeted {
+			g.occupied.Add(1)
+		}
+	}
+	return g
+}
+
+// Resize returns a new filter of at least newSize slots, configured like f,
+// holding the ids in f rehashed into their slots in the new filter. Ids that
+// collide in the new filter are dropped. f is not changed. It returns
+// ErrIndexBitsTooFew if f was made with WithIndexBits and the new filter has
+// more than 2^n slots.
+func (f *Filter) Resize(newSize int) (*Filter, error) {
+	g, err := f.emptyCopy(newSize)
+	if err != nil {
+		return nil, err
+	}
+	g.uint64s.Store(f.uint64s.Load())
+	f.Range(func(id []byte) bool {
+		g.insert(g.reduce(g.storedSum(nil, id)), id)
+		return true
+	})
+	return g, nil
+}
+
+// Shrink is Resize to a smaller size, e.g. to reclaim memory once fewer ids
+// are seen. Ids that collide in the smaller filter are dropped. It returns an
+// error if newSize is invalid for NewFilter, or ErrSizeNotSmaller if the new
+// filter would be at least as large as f.
+func (f *Filter) Shrink(newSize int) (*Filter, error) {
+	if newSize <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	rounded := newSize
+	if !f.exact {
+		rounded = RoundedSize(newSize)
+	}
+	if rounded == 0 || rounded >= f.Size() {
+		return nil, ErrSizeNotSmaller
+	}
+	return f.Resize(newSize)
+}
+
+// ReplaceWith atomically makes other's slots f's, so that everyone holding f
+// sees other's ids from then on, e.g. to put a filter rebuilt in the
+// background in place. other may have a different size, but must index ids
+// like f, with the same hash and seed, or ErrHashMismatch is returned. The two
+// share the slots afterwards, so other must not be used any more. Calls on f
+// racing with ReplaceWith use either the old or the new slots.
+func (f *Filter) ReplaceWith(other *Filter) error {
+	if !f.indexesLike(other) {
+		return ErrHashMismatch
+	}
+	f.grow.Lock()
+	defer f.grow.Unlock()
+	f.table.Store(other.table.Load())
+	f.occupied.Store(other.occupied.Load())
+	if other.uint64s.Load() {
+		f.uint64s.Store(true)
+	}
+	return nil
+}
+
+// indexesLike returns true if f and other store and index ids alike, judging
+// by the sums of a few probe ids, since hash functions can't be compared.
+func (f *Filter) indexesLike(other *Filter) bool {
+	if f.fingerprint != other.fingerprint || f.exact != other.exact || !bytes.Equal(f.seed, other.seed) {
+		return false
+	}
+	for _, id := range [][]byte{nil, {0}, []byte("oppobloom"), []byte("an id longer than a block of most hashes, which are 64 bytes")} {
+		if f.caculateHash(f.key(id)) != other.caculateHash(other.key(id)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Grow doubles the size of f in place, rehashing its ids into the new slots,
+// so that everyone holding f sees the larger filter. It returns
+// ErrSizeTooLarge if f cannot double.
+//
+// Contains, Peek and Forget stay lock-free while f grows: they keep using the
+// old slots until Grow replaces them all at once. As a result an id added
+// while Grow runs may be lost, and an id forgotten while it runs may come
+// back, since Grow may have copied it already. Either way Contains never
+// reports an id that was not added. Concurrent Grow calls run one at a time.
+func (f *Filter) Grow() error {
+	f.grow.Lock()
+	defer f.grow.Unlock()
+	return f.growLocked(f.table.Load())
+}
+
+// tryGrow grows the filter for WithAutoGrow, unless it already grew past t or
+// another goroutine is growing it, in which case the caller carries on with
+// the old slots.
+func (f *Filter) tryGrow(t *table) {
+	if !f.grow.TryLock() {
+		return
+	}
+	defer f.grow.Unlock()
+	if f.table.Load() == t {
+		f.growLocked(t)
+	}
+}
+
+// growLocked doubles old, the current table, while f.grow is held.
+func (f *Filter) growLocked(old *table) error {
+	if len(old.slots) > maxFilterSize/2 || f.indexBits > 0 && f.indexBits < 64 && 2*len(old.slots) > 1<<f.indexBits {
+		return ErrSizeTooLarge
+	}
+	t := f.newTable(2 * len(old.slots))
+	h := f.hashes.Get().(hash.Hash32)
+	defer f.hashes.Put(h)
+	occupied := int64(0)
+	for i := range old.slots {
+		p := old.slot(i)
+		if p == nil {
+			continue
+		}
+		j := t.reduce(f.storedSum(h, *p))
+		if t.slots[j].Load() == nil {
+			occupied++
+		}
+		t.slots[j].Store(p)
+		t.setPriority(j, old.priority(uint64(i)))
+	}
+	f.occupied.Store(occupied)
+	f.table.Store(t)
+	return nil
+}
+
+// emptyCopy returns an empty filter of at least size slots configured like f.
+// Like WithIndexBits, it returns ErrIndexBitsTooFew if f's index bits cannot
+// reach every slot.
+func (f *Filter) emptyCopy(size int) (*Filter, error) {
+	g, err := newFilter(size, maxFilterSize, f.exact, f.newHash, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.indexBits > 0 && f.indexBits < 64 && g.Size() > 1<<f.indexBits {
+		return nil, ErrIndexBitsTooFew
+	}
+	g.config = f.config
+	if g.collisions {
+		g.table.Store(g.newTable(g.Size()))
+	}
+	return g, nil
+}
+
+// Range calls fn for each id in the filter, in slot order, until fn returns
+// false. Slots are read one at a time, so ids inserted or forgotten while Range
+// runs may or may not be seen. fn is passed the slice stored in the filter,
+// which the filter never modifies but which is shared with whoever inserted
+// it; fn must not modify it.
+func (f *Filter) Range(fn func(id []byte) bool) {
+	f.table.Load().each(func(p *[]byte) bool { return fn(*p) })
+}
+
+// slot is Filter.slot for t.
+func (t *table) slot(i int) *[]byte {
+	p := t.slots[i].Load()
+	if p == forgeted {
+		return nil
 	}
+	return p
 }
 
-func (f *Filter) caculateIndex(id []byte) int32 {
-	h := md5UintHash{md5.New()}
+// each calls fn with each slot of t holding an id until fn returns false.
+func (t *table) each(fn func(p *[]byte) bool) {
+	for i := range t.slots {
+		if p := t.slot(i); p != nil && !fn(p) {
+			return
+		}
+	}
+}
+
+// MemoryBytes estimates the heap footprint of the filter in bytes: its array
+// of slot pointers and any per-slot counts, plus a slice header and the bytes
+// of each id stored. It doesn't count the rounding of allocations, and counts
+// the bytes of an id stored in several slots, e.g. with WithInterning, once
+// per slot. Like Count it is a best-effort snapshot.
+func (f *Filter) MemoryBytes() int {
+	t := f.table.Load()
+	n := len(t.slots)*int(unsafe.Sizeof(t.slots[0])) + len(t.collisions)*4
+	if ps := t.priorities.Load(); ps != nil {
+		n += len(*ps) * 4
+	}
+	t.each(func(p *[]byte) bool {
+		n += int(unsafe.Sizeof(*p)) + len(*p)
+		return true
+	})
+	return n
+}
+
+// ArrayBytes returns the size in bytes of the filter's array of slots, a
+// pointer per slot, as counted by MemoryBytes, e.g. to check how it fits in a
+// cache or NUMA node. Go only guarantees that the array is aligned for
+// pointers: the current runtime starts arrays of more than 32KB on a page
+// boundary, and so on a cache line, but smaller ones may straddle lines.
+func (f *Filter) ArrayBytes() uintptr {
+	t := f.table.Load()
+	return uintptr(len(t.slots)) * unsafe.Sizeof(t.slots[0])
+}
+
+// LengthHistogram returns a map from each length of the ids in the filter to
+// the number of slots holding an id of that length, to see, e.g., whether a
+// few long ids take most of MemoryBytes. Like Count it is a best-effort
+// snapshot.
+func (f *Filter) LengthHistogram() map[int]int {
+	lengths := make(map[int]int)
+	f.table.Load().each(func(p *[]byte) bool {
+		lengths[len(*p)]++
+		return true
+	})
+	return lengths
+}
+
+// RangeSnapshot is Range but also passes fn the index of each id's slot, as
+// OccupiedIndices reports them, and a copy of the id, which fn may keep and
+// modify. Range passes the slices stored instead, which are shared with
+// whoever inserted them and change if they reuse their buffers. Slots are read
+// one at a time, as by Range.
+func (f *Filter) RangeSnapshot(fn func(index int, id []byte) bool) {
+	t := f.table.Load()
+	for i := range t.slots {
+		if p := t.slot(i); p != nil && !fn(i, bytes.Clone(*p)) {
+			return
+		}
+	}
+}
+
+// BucketOccupied returns true if the slot at index holds an id, as reported
+// by OccupiedIndices, reading just that slot. index is reduced to the
+// filter's size like ContainsHashed's, so any index is valid.
+func (f *Filter) BucketOccupied(index int) bool {
+	return f.slot(uint64(index)) != nil
+}
+
+// OccupiedIndices returns the indexes of the slots holding an id in ascending
+// order, as ContainsWithIndex reports them. Like Count it is a best-effort
+// snapshot.
+func (f *Filter) OccupiedIndices() []int {
+	var indices []int
+	t := f.table.Load()
+	for i := range t.slots {
+		if t.slot(i) != nil {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// Snapshot returns a map from the index of each slot holding an id, as
+// OccupiedIndices reports them, to a copy of the id, which the caller may
+// modify. Like Count it is a best-effort snapshot: slots are read one at a
+// time, so under concurrent use it may mix older and newer slots. Each slot is
+// a single pointer, though, read atomically, so every id returned was really
+// in its slot when it was read, and an id is only ever stored in the slot it
+// hashes to, so no id is returned twice, however heavy the writes.
+func (f *Filter) Snapshot() map[int][]byte {
+	snapshot := make(map[int][]byte)
+	t := f.table.Load()
+	for i := range t.slots {
+		if p := t.slot(i); p != nil {
+			snapshot[i] = bytes.Clone(*p)
+		}
+	}
+	return snapshot
+}
+
+// IsEmpty returns true if no slot holds an id. Unlike Count() == 0 it doesn't
+// walk the slots, but reads the count of occupied slots kept up to date by
+// every insert into an empty slot and every forget.
+func (f *Filter) IsEmpty() bool {
+	return f.occupied.Load() == 0
+}
+
+// Count returns the number of slots currently holding an id. Under concurrent
+// use it is a best-effort snapshot, as slots may change while it is counting.
+func (f *Filter) Count() int {
+	n := 0
+	f.table.Load().each(func(*[]byte) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// LoadFactor returns the fraction of slots currently holding an id, from 0 for
+// an empty filter to 1 for a full one. Like Count it is a best-effort
+// snapshot.
+func (f *Filter) LoadFactor() float64 {
+	return float64(f.Count()) / float64(f.Size())
+}
+
+// CapacityAt returns how many distinct ids the filter can be expected to take,
+// starting empty, before LoadFactor exceeds maxLoadFactor, by inverting the
+// math of RecommendSize. It returns math.MaxInt for a maxLoadFactor of 1,
+// which is never exceeded, and 0 if maxLoadFactor is not in (0, 1].
+func (f *Filter) CapacityAt(maxLoadFactor float64) int {
+	if !(maxLoadFactor > 0 && maxLoadFactor <= 1) {
+		return 0
+	}
+	if maxLoadFactor == 1 {
+		return math.MaxInt
+	}
+	size := f.Size()
+	capacity := math.Log1p(-maxLoadFactor) / math.Log1p(-1/float64(size))
+	if capacity >= math.MaxInt {
+		return math.MaxInt
+	}
+	n := int(capacity)
+	for n > 0 && expectedFalseNegativeRate(n, size) > maxLoadFactor {
+		n--
+	}
+	return n
+}
+
+// FalseNegativeRate estimates the probability that the next new id evicts an
+// id already in the filter, which will then be reported as not contained.
+// Each slot holds a single id, so that is the probability the new id's slot is
+// occupied: Count() / Size(), assuming the hash spreads ids uniformly.
+func (f *Filter) FalseNegativeRate() float64 {
+	return float64(f.Count()) / float64(f.Size())
+}
+
+func (f *Filter) caculateIndex(id []byte) uint64 {
+	return f.reduce(f.caculateHash(id))
+}
+
+// caculateHash is caculateSum with a pooled hash.
+func (f *Filter) caculateHash(id []byte) uint64 {
+	if f.fingerprint || f.index != nil || f.adaptive && len(id) <= maxShortID {
+		return f.caculateSum(nil, id)
+	}
+	h := f.hashes.Get().(hash.Hash32)
+	sum := f.caculateSum(h, id)
+	f.hashes.Put(h)
+	return sum
+}
+
+// caculateIndexWith is caculateIndex using h, which is reset first, so that
+// one hash can be reused for many ids.
+func (f *Filter) caculateIndexWith(h hash.Hash32, id []byte) uint64 {
+	return f.reduce(f.caculateSum(h, id))
+}
+
+// caculateSum is caculateIndexWith before masking.
+func (f *Filter) caculateSum(h hash.Hash32, id []byte) uint64 {
+	if f.index != nil {
+		return uint64(f.index(id))
+	}
+	if f.fingerprint {
+		return f.narrow(foldDigest64(f.byteOrder(), id))
+	}
+	if f.adaptive && len(id) <= maxShortID {
+		return f.narrow(f.shortSum(id))
+	}
+	h.Reset()
+	f.writeSeed(h)
 	h.Write(id)
-	uindex := h.Sum32() & f.sizeMask
+	return f.narrow(sum64(h))
+}
 
-	return int32(uindex)
+// narrow folds sum into its low f.indexBits bits, with WithIndexBits.
+func (f *Filter) narrow(sum uint64) uint64 {
+	switch f.indexBits {
+	case 16:
+		x := uint32(sum>>32) ^ uint32(sum)
+		return uint64(x>>16 ^ x&0xffff)
+	case 32:
+		return uint64(uint32(sum>>32) ^ uint32(sum))
+	}
+	return sum
 }
 
-// Size return the size of the hashmap
+// byteOrder returns the order the filter decodes digest words in.
+func (f *Filter) byteOrder() binary.ByteOrder {
+	if f.order == nil {
+		return binary.LittleEndian
+	}
+	return f.order
+}
+
+// mask returns the mask of the filter's current table.
+func (f *Filter) mask() uint64 {
+	return f.table.Load().mask
+}
+
+// reduce is table.reduce for the filter's current table.
+func (f *Filter) reduce(sum uint64) uint64 {
+	return f.table.Load().reduce(sum)
+}
+
+func (f *Filter) caculateStringIndex(id string) uint64 {
+	if f.index != nil {
+		return f.caculateIndex([]byte(id))
+	}
+	if f.adaptive && len(id) <= maxShortID {
+		var short [maxShortID]byte
+		return f.reduce(f.narrow(f.shortSum(short[:copy(short[:], id)])))
+	}
+	h := f.hashes.Get().(hash.Hash32)
+	h.Reset()
+	f.writeSeed(h)
+	writeString(h, id)
+	index := f.reduce(f.narrow(sum64(h)))
+	f.hashes.Put(h)
+	return index
+}
+
+// probeHash makes and uses a hash with h, returning an error instead of
+// panicking if h doesn't work, as MD5 may not in FIPS 140 restricted builds.
+func probeHash(h func() hash.Hash32) (probed hash.Hash32, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %v", ErrHashUnavailable, r)
+		}
+	}()
+	probed = h()
+	probed.Write(nil)
+	sum64(probed)
+	return probed, nil
+}
+
+// newHashPool returns a pool of hashes made by h, which lets lookups run
+// without allocating a hash each.
+func newHashPool(h func() hash.Hash32) *sync.Pool {
+	return &sync.Pool{New: func() any { return h() }}
+}
+
+// writeSeed writes the filter's seed, if it has one, ahead of the id.
+func (f *Filter) writeSeed(h hash.Hash32) {
+	if f.seed != nil {
+		h.Write(f.seed)
+	}
+}
+
+// writeString writes id to h through a pooled buffer, a chunk at a time, as
+// io.WriteString would copy it into a new slice for hashes like MD5 that lack
+// a WriteString method.
+func writeString(h hash.Hash32, id string) {
+	buf := stringBufs.Get().(*[64]byte)
+	for len(id) > 0 {
+		n := copy(buf[:], id)
+		h.Write(buf[:n])
+		id = id[n:]
+	}
+	stringBufs.Put(buf)
+}
+
+var stringBufs = sync.Pool{New: func() any { return new([64]byte) }}
+
+// hash64 lets a hash.Hash64 be used where a hash.Hash32 is expected. sum64
+// still uses its Sum64.
+type hash64 struct {
+	hash.Hash64
+}
+
+func (h hash64) Sum32() uint32 {
+	sum := h.Sum64()
+	return uint32(sum>>32) ^ uint32(sum)
+}
+
+// sum64 returns the hash of what was written to h, using all 64 bits when h
+// is also a hash.Hash64 so that the high bits of large masks are used.
+func sum64(h hash.Hash32) uint64 {
+	if h64, ok := h.(hash.Hash64); ok {
+		return h64.Sum64()
+	}
+	return uint64(h.Sum32())
+}
+
+// String returns a summary of the filter like
+// "oppobloom.Filter{size=1024, count=37, load=0.036}", with a best-effort
+// count as from Count.
+func (f *Filter) String() string {
+	count := f.Count()
+	return fmt.Sprintf("oppobloom.Filter{size=%d, count=%d, load=%.3f}", f.Size(), count, float64(count)/float64(f.Size()))
+}
+
+// Size return the size of the hashmap. On 64-bit platforms it may exceed 2^32.
 func (f *Filter) Size() int {
-	return len(f.array)
+	return len(f.table.Load().slots)
+}
+
+// Validate checks the filter's internal invariants and returns an error
+// wrapping ErrCorruptFilter that describes the first one violated, or nil. It
+// catches filters that were not made by a constructor or were damaged, e.g.
+// through unsafe code, and is cheap enough for periodic health checks.
+func (f *Filter) Validate() error {
+	t := f.table.Load()
+	switch {
+	case t == nil:
+		return fmt.Errorf("%w: no slots", ErrCorruptFilter)
+	case len(t.slots) == 0 || !t.exact && len(t.slots)&(len(t.slots)-1) != 0:
+		return fmt.Errorf("%w: %d slots is not a power of 2", ErrCorruptFilter, len(t.slots))
+	case t.mask != uint64(len(t.slots))-1:
+		return fmt.Errorf("%w: mask %#x does not match %d slots", ErrCorruptFilter, t.mask, len(t.slots))
+	case t.collisions != nil && len(t.collisions) != len(t.slots):
+		return fmt.Errorf("%w: %d collision counts for %d slots", ErrCorruptFilter, len(t.collisions), len(t.slots))
+	case forgeted == nil:
+		return fmt.Errorf("%w: forgotten slot sentinel is nil", ErrCorruptFilter)
+	case f.hashes == nil:
+		return fmt.Errorf("%w: no hash", ErrCorruptFilter)
+	}
+	if ps := t.priorities.Load(); ps != nil && len(*ps) != len(t.slots) {
+		return fmt.Errorf("%w: %d priorities for %d slots", ErrCorruptFilter, len(*ps), len(t.slots))
+	}
+	if n := f.occupied.Load(); n < 0 || n > int64(len(t.slots)) {
+		return fmt.Errorf("%w: %d occupied of %d slots", ErrCorruptFilter, n, len(t.slots))
+	}
+	return nil
 }
 
 type md5UintHash struct {
 	hash.Hash // a hack with knowledge of how md5 works
+	sum       [md5.Size]byte
+	order     binary.ByteOrder // of the digest's words
 }
 
-func (m md5UintHash) Sum32() uint32 {
-	sum := m.Sum(nil)
-	x := uint32(sum[0])
-	for _, val := range sum[1:3] {
-		x = x << 3
-		x += uint32(val)
+func newMD5UintHash() hash.Hash32 {
+	return &md5UintHash{Hash: md5.New(), order: binary.LittleEndian}
+}
+
+// newMD5UintHashOrder returns a factory of MD5 hashes that decode the words of
+// the digest in order.
+func newMD5UintHashOrder(order binary.ByteOrder) func() hash.Hash32 {
+	return func() hash.Hash32 {
+		return &md5UintHash{Hash: md5.New(), order: order}
+	}
+}
+
+// Sum32 folds the whole 16 byte digest into a uint32 by XORing its four words
+// together.
+func (m *md5UintHash) Sum32() uint32 {
+	sum := m.Sum(m.sum[:0])
+	var x uint32
+	for i := 0; i+4 <= len(sum); i += 4 {
+		x ^= m.order.Uint32(sum[i:])
 	}
 	return x
 }
 
-// Returns the id that was in the slice at the given index after putting the
-// new id in the slice at that index, atomically.
-func getAndSet(arr []*[]byte, index int32, id []byte) []byte {
-	indexPtr := (*unsafe.Pointer)(unsafe.Pointer(&arr[index]))
-	idUnsafe := unsafe.Pointer(&id)
-	var oldId []byte
-	for {
-		oldIdUnsafe := atomic.LoadPointer(indexPtr)
-		if atomic.CompareAndSwapPointer(indexPtr, oldIdUnsafe, idUnsafe) {
-			oldIdPtr := (*[]byte)(oldIdUnsafe)
-			if oldIdPtr != nil {
-				oldId = *oldIdPtr
+// Sum64 folds the digest into a uint64 with foldDigest64.
+func (m *md5UintHash) Sum64() uint64 {
+	return foldDigest64(m.order, m.Sum(m.sum[:0]))
+}
+
+// foldDigest64 folds a 16 byte digest into a uint64, decoding its words in
+// order. The low 32 bits are the XOR of all four words, like
+// md5UintHash.Sum32, and the high 32 bits are the XOR of the first two.
+func foldDigest64(order binary.ByteOrder, sum []byte) uint64 {
+	hi := order.Uint32(sum[0:]) ^ order.Uint32(sum[4:])
+	lo := hi ^ order.Uint32(sum[8:]) ^ order.Uint32(sum[12:])
+	return uint64(hi)<<32 | uint64(lo)
+}
+
+// Returns the id that was in the slot after putting the new id in it,
+// atomically. ok is false if the slot was empty or held the forgeted sentinel.
+// failed is the number of compare-and-swaps that failed first.
+func getAndSet(item *atomic.Pointer[[]byte], id []byte) (oldId []byte, ok bool, failed int) {
+	oldId, ok, _, failed = tryGetAndSet(item, id, nil)
+	return oldId, ok, failed
+}
+
+// tryGetAndSet is getAndSet but gives up, with swapped false, if giveUp is
+// not nil and returns true. It is called before every compare-and-swap with
+// the number that failed so far.
+func tryGetAndSet(item *atomic.Pointer[[]byte], id []byte, giveUp func(failed int) bool) (oldId []byte, ok, swapped bool, failed int) {
+	for ; ; failed++ {
+		oldIdPtr := item.Load()
+		if giveUp != nil && giveUp(failed) {
+			return nil, false, false, failed
+		}
+		if item.CompareAndSwap(oldIdPtr, &id) {
+			if oldIdPtr != nil && oldIdPtr != forgeted {
+				oldId, ok = *oldIdPtr, true
 			}
-			break
+			return oldId, ok, true, failed
 		}
 	}
-	return oldId
 }
@@ -4,48 +4,2002 @@
 package oppobloom
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"math/rand"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"unsafe"
 )
 
 func TestTheBasics(t *testing.T) {
 	f, _ := NewFilter(2)
 	twentyNineId := []byte{27, 28, 29}
-	thirtyId := []byte{27, 28, 30}
+	thirtyTwoId := []byte{27, 28, 32}
 	thirtyThreeId := []byte{27, 28, 33}
 	shouldNotContain(t, "nothing should be contained at all", f, twentyNineId)
 	f.Forget(twentyNineId)
 	shouldNotContain(t, "nothing should be contained at all agin", f, twentyNineId)
 	shouldContain(t, "now it should", f, twentyNineId)
-	shouldNotContain(t, "false unless the hash collides", f, thirtyId)
+	shouldNotContain(t, "false unless the hash collides", f, thirtyTwoId)
 	shouldContain(t, "original should still return true", f, twentyNineId)
-	shouldContain(t, "new array should still return true", f, thirtyId)
+	shouldContain(t, "new array should still return true", f, thirtyTwoId)
 
-	// Handling collisions. {27, 28, 33} and {27, 28, 30} hash to the same
+	// Handling collisions. {27, 28, 33} and {27, 28, 32} hash to the same
 	// index using the current hash function inside Filter.
 	shouldNotContain(t, "colliding array returns false", f, thirtyThreeId)
 	shouldContain(t, "colliding array returns true in second call", f, thirtyThreeId)
-	shouldNotContain(t, "original colliding array returns false", f, thirtyId)
-	shouldContain(t, "original colliding array returns true", f, thirtyId)
+	shouldNotContain(t, "original colliding array returns false", f, thirtyTwoId)
+	shouldContain(t, "original colliding array returns true", f, thirtyTwoId)
 	shouldNotContain(t, "colliding array returns false", f, thirtyThreeId)
 }
 
+func TestAdd(t *testing.T) {
+	f, _ := NewFilter(1024)
+	id := []byte{27, 28, 29}
+	f.Add(id)
+	shouldContain(t, "added id", f, id)
+	f.Add(id)
+	if f.Count() != 1 {
+		t.Errorf("adding an id twice should use one slot, count: %d", f.Count())
+	}
+}
+
+func TestContainsAll(t *testing.T) {
+	f, _ := NewFilter(1024)
+	g, _ := NewFilter(1024)
+	ids := [][]byte{{1}, {2}, {1}, {3}, {2}, {2}}
+	present := f.ContainsAll(ids)
+	if len(present) != len(ids) {
+		t.Fatalf("should return %d results, got: %d", len(ids), len(present))
+	}
+	for i, id := range ids {
+		if want := g.Contains(id); present[i] != want {
+			t.Errorf("result %d for %v should be %v like Contains", i, id, want)
+		}
+	}
+	if present[0] || !present[2] || !present[5] {
+		t.Errorf("repeated ids should only be contained after their first occurrence: %v", present)
+	}
+}
+
+func TestContainsAllParallel(t *testing.T) {
+	// Every id gets its own slot, so that results don't depend on the order
+	// the ids are inserted in.
+	f, _ := NewFilterForTest(1024, func(id []byte) int { return int(binary.BigEndian.Uint32(id)) })
+	ids := make([][]byte, 1000)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+	}
+	f.ContainsAll(ids[:500])
+	for _, workers := range []int{0, 1, 3, 2000} {
+		g := f.Clone()
+		present := g.ContainsAllParallel(ids, workers)
+		if len(present) != len(ids) {
+			t.Fatalf("should return %d results, got: %d", len(ids), len(present))
+		}
+		for i, id := range ids {
+			if want := f.Peek(id); present[i] != want {
+				t.Errorf("%d workers: result %d for %v should be %v", workers, i, id, want)
+			}
+			if !g.Peek(id) {
+				t.Errorf("%d workers: id %v should be contained afterwards", workers, id)
+			}
+		}
+	}
+	if present := f.ContainsAllParallel(nil, 4); len(present) != 0 {
+		t.Errorf("empty batch should return no results")
+	}
+}
+
+func TestForgetAll(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	ids := [][]byte{{1}, {2}, {3}, {4}, {5}, {6}}
+	f.ContainsAll(ids)
+	f.ForgetAll(ids[:3])
+	for i, id := range ids {
+		if want := i >= 3; f.Peek(id) != want {
+			t.Errorf("id %v should be contained: %v", id, want)
+		}
+	}
+	f.ForgetAll(ids[:3])
+	if c := f.Count(); c != 3 {
+		t.Errorf("forgetting absent ids should not change the count, got: %d", c)
+	}
+}
+
+func TestEmptyID(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	shouldNotContain(t, "nil id", f, nil)
+	shouldContain(t, "empty id after nil", f, []byte{})
+	if !f.Peek(nil) || !f.Peek([]byte{}) {
+		t.Errorf("nil and empty ids should both peek as the stored empty id")
+	}
+	f.Forget([]byte{})
+	if f.Peek(nil) || f.Count() != 0 {
+		t.Errorf("forgetting the empty id should forget nil too")
+	}
+
+	for _, id := range [][]byte{nil, {}} {
+		if present, err := f.ContainsE(id); err != ErrEmptyID || present {
+			t.Errorf("ContainsE(%#v) should return ErrEmptyID, got: %v, %v", id, present, err)
+		}
+	}
+	if f.Count() != 0 {
+		t.Errorf("ContainsE should not store an empty id")
+	}
+	if present, err := f.ContainsE([]byte{1}); err != nil || present {
+		t.Errorf("fresh id should not be contained, got: %v, %v", present, err)
+	}
+	if present, err := f.ContainsE([]byte{1}); err != nil || !present {
+		t.Errorf("seen id should be contained, got: %v, %v", present, err)
+	}
+}
+
+func TestContainsCopy(t *testing.T) {
+	var lines []string
+	for i := 0; i < 100; i++ {
+		lines = append(lines, fmt.Sprintf("line %02d", i))
+	}
+	// scan adds each line with add, reading them with a small buffer that is
+	// reused often, overwriting the slices of earlier lines.
+	scan := func(add func(id []byte) bool) {
+		s := bufio.NewScanner(strings.NewReader(strings.Join(lines, "\n")))
+		s.Buffer(make([]byte, 16), 16)
+		for s.Scan() {
+			add(s.Bytes())
+		}
+		if err := s.Err(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	missing := func(f *Filter) int {
+		n := 0
+		for _, line := range lines {
+			if !f.Peek([]byte(line)) {
+				n++
+			}
+		}
+		return n
+	}
+
+	plain, _ := NewFilter(1 << 16)
+	scan(plain.Contains)
+	if missing(plain) == 0 {
+		t.Errorf("reused scanner buffer should have corrupted the stored lines")
+	}
+	copied, _ := NewFilter(1 << 16)
+	scan(copied.ContainsCopy)
+	if n := missing(copied); n != 0 {
+		t.Errorf("copied lines should all be contained, %d are missing", n)
+	}
+	shouldContain(t, "copied line", copied, []byte(lines[0]))
+}
+
+func TestContainsHashed(t *testing.T) {
+	f, _ := NewFilter(1024)
+	for i := 0; i < 100; i++ {
+		id := []byte{byte(i), 1, 2}
+		index := f.caculateIndex(id)
+		if f.ContainsHashed(index, id) {
+			t.Errorf("fresh id %v should not be contained", id)
+		}
+		if !f.Contains(id) || !f.ContainsHashed(index+uint64(f.Size()), id) {
+			t.Errorf("id %v added at its own index should be contained", id)
+		}
+	}
+	id := []byte{27, 28, 29}
+	f.ContainsHashed(f.caculateIndex(id)+1, id)
+	if f.Peek(id) {
+		t.Errorf("id added at another index should not be found by Peek")
+	}
+}
+
+func TestContainsHashedOutOfRange(t *testing.T) {
+	const huge = math.MaxUint64 - 5
+	pow2, _ := NewFilter(1024)
+	exact, _ := NewExactSizeFilter(1000)
+	for _, c := range []struct {
+		filter *Filter
+		index  uint64
+	}{
+		{pow2, huge & 1023},
+		{exact, huge % 1000},
+	} {
+		id := []byte{27, 28, 29}
+		if c.filter.ContainsHashed(huge, id) {
+			t.Errorf("fresh id should not be contained")
+		}
+		if !c.filter.ContainsHashed(c.index, id) || !c.filter.peekAt(c.index, id) {
+			t.Errorf("id at huge index should be in slot %d of %d", c.index, c.filter.Size())
+		}
+	}
+}
+
+func TestContainsPrehashed(t *testing.T) {
+	md5Sum := func(id []byte) []byte { s := md5.Sum(id); return s[:] }
+	hashSum := func(h hash.Hash) func(id []byte) []byte {
+		return func(id []byte) []byte {
+			h.Reset()
+			h.Write(id)
+			return h.Sum(nil)
+		}
+	}
+	for _, c := range []struct {
+		name   string
+		filter func() (*Filter, error)
+		sum    func(id []byte) []byte
+	}{
+		{"md5", func() (*Filter, error) { return NewFilter(1 << 16) }, md5Sum},
+		{"big-endian md5", func() (*Filter, error) { return NewFilter(1<<16, WithByteOrder(binary.BigEndian)) }, md5Sum},
+		{"fingerprint", func() (*Filter, error) { return NewFingerprintFilter(1 << 16) }, md5Sum},
+		{"adaptive", func() (*Filter, error) { return NewFilter(1<<16, WithAdaptiveHash()) }, md5Sum},
+		{"crc32", func() (*Filter, error) { return NewFilterWithHash(1<<16, crc32.NewIEEE) }, hashSum(crc32.NewIEEE())},
+		{"fnv64a", func() (*Filter, error) { return NewFilterWithHash64(1<<16, fnv.New64a) }, hashSum(fnv.New64a())},
+	} {
+		f, _ := c.filter()
+		g, _ := c.filter()
+		for i := 0; i < 100; i++ {
+			id := binary.BigEndian.AppendUint32([]byte{byte(i)}, uint32(i))
+			if i%2 == 0 {
+				id = append(id, "and a longer tail"...)
+			}
+			for round := 0; round < 2; round++ {
+				want := g.Contains(id)
+				got, err := f.ContainsPrehashed(c.sum(id), id)
+				if err != nil || got != want {
+					t.Fatalf("%s: ContainsPrehashed(%v) should be %v like Contains, got: %v, %v", c.name, id, want, got, err)
+				}
+			}
+			if !f.Peek(id) {
+				t.Errorf("%s: prehashed id %v should be found by Peek", c.name, id)
+			}
+		}
+	}
+
+	f, _ := NewFilter(1024)
+	id := []byte{27, 28, 29}
+	if present, err := f.ContainsPrehashed(md5Sum(id)[:8], id); err != ErrInvalidSum || present {
+		t.Errorf("short sum should return ErrInvalidSum, got: %v, %v", present, err)
+	}
+	if f.Count() != 0 {
+		t.Errorf("invalid sum should not add anything")
+	}
+	seeded, _ := NewFilterWithSeed(1024, 42)
+	if _, err := seeded.ContainsPrehashed(md5Sum(id), id); err != ErrSeededSum {
+		t.Errorf("seeded filter should return ErrSeededSum, got: %v", err)
+	}
+}
+
+func TestContainsWithHash(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	g, _ := NewFilter(1 << 10)
+	seeded, _ := NewFilterWithSeed(1<<16, 42)
+	id := []byte{27, 28, 29}
+	present, hash := f.ContainsWithHash(id)
+	if present {
+		t.Errorf("fresh id should not be contained")
+	}
+	if index := f.caculateIndex(id); hash&f.mask() != index {
+		t.Errorf("masked hash %#x should be the index %d", hash&f.mask(), index)
+	}
+	if hash <= f.mask() {
+		t.Errorf("hash %#x should not be masked", hash)
+	}
+	if present, again := f.ContainsWithHash(id); !present || again != hash {
+		t.Errorf("seen id should be contained with the same hash %#x, got: %v, %#x", hash, present, again)
+	}
+	if _, other := g.ContainsWithHash(id); other != hash {
+		t.Errorf("hash should not depend on the size, got: %#x and %#x", hash, other)
+	}
+	if _, other := seeded.ContainsWithHash(id); other == hash {
+		t.Errorf("hash should depend on the seed")
+	}
+	if !g.ContainsHashed(hash, id) {
+		t.Errorf("hash should find id's slot with ContainsHashed")
+	}
+}
+
+func TestContainsEvicted(t *testing.T) {
+	f, _ := NewFilter(1)
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	if present, evicted := f.ContainsEvicted(first); present || evicted {
+		t.Errorf("empty slot should evict nothing, present: %v, evicted: %v", present, evicted)
+	}
+	if present, evicted := f.ContainsEvicted(second); present || !evicted {
+		t.Errorf("second distinct id should evict, present: %v, evicted: %v", present, evicted)
+	}
+	if present, evicted := f.ContainsEvicted(second); !present || evicted {
+		t.Errorf("repeated id should not evict, present: %v, evicted: %v", present, evicted)
+	}
+}
+
+func TestNotContains(t *testing.T) {
+	f, _ := NewFilter(1)
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	if !f.NotContains(first) {
+		t.Errorf("fresh id should not be contained")
+	}
+	if f.NotContains(first) || !f.Contains(first) {
+		t.Errorf("seen id should be contained")
+	}
+	if !f.NotContains(second) {
+		t.Errorf("fresh colliding id should not be contained")
+	}
+	if !f.NotContains(first) {
+		t.Errorf("evicted id should not be contained")
+	}
+}
+
+func TestContainsScratch(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	g, _ := NewFilter(1 << 16)
+	buf := make([]byte, 4)
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 1000; i++ {
+			binary.BigEndian.PutUint32(buf, uint32(i))
+			want := g.Contains([]byte{buf[0], buf[1], buf[2], buf[3]})
+			if got := f.ContainsScratch(buf); got != want {
+				t.Errorf("ContainsScratch(%v) in round %d should be %v like Contains, got: %v", buf, round, want, got)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(buf, 999)
+	if allocs := testing.AllocsPerRun(100, func() { f.ContainsScratch(buf) }); allocs != 0 {
+		t.Errorf("looking up a stored id should not allocate, allocated: %f", allocs)
+	}
+}
+
+func TestContainsEvict(t *testing.T) {
+	f, _ := NewFilter(1)
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	present, evicted := f.ContainsEvict(first)
+	if present || evicted != nil {
+		t.Errorf("empty slot should evict nothing, present: %v, evicted: %v", present, evicted)
+	}
+	present, evicted = f.ContainsEvict(second)
+	if present || !bytes.Equal(evicted, first) {
+		t.Errorf("colliding id should evict %v, present: %v, evicted: %v", first, present, evicted)
+	}
+	present, evicted = f.ContainsEvict(second)
+	if !present || evicted != nil {
+		t.Errorf("repeated id should evict nothing, present: %v, evicted: %v", present, evicted)
+	}
+	f.Forget(second)
+	present, evicted = f.ContainsEvict(first)
+	if present || evicted != nil {
+		t.Errorf("forgotten slot should evict nothing, present: %v, evicted: %v", present, evicted)
+	}
+}
+
+func TestOnEvict(t *testing.T) {
+	f, _ := NewFilter(1)
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	calls := 0
+	f.OnEvict(func(evicted, inserted []byte) {
+		calls++
+		if !bytes.Equal(evicted, first) || !bytes.Equal(inserted, second) {
+			t.Errorf("callback should get %v and %v, got: %v and %v", first, second, evicted, inserted)
+		}
+	})
+	f.Contains(first)
+	f.Contains(first)
+	if calls != 0 {
+		t.Errorf("inserting into an empty slot or reinserting should not evict")
+	}
+	f.Contains(second)
+	if calls != 1 {
+		t.Errorf("callback should fire once on a collision, fired %d times", calls)
+	}
+	f.OnEvict(nil)
+	f.Contains(first)
+	if calls != 1 {
+		t.Errorf("removed callback should not fire")
+	}
+}
+
+func TestContainsWithIndex(t *testing.T) {
+	f, _ := NewFilter(2)
+	// {27, 28, 32} and {27, 28, 33} share a slot, see TestTheBasics.
+	first := []byte{27, 28, 32}
+	second := []byte{27, 28, 33}
+	present, index, occupant := f.ContainsWithIndex(first)
+	if present || index != 1 || occupant != nil {
+		t.Errorf("fresh id should go in empty slot 1, got: %v, %d, %v", present, index, occupant)
+	}
+	present, index, occupant = f.ContainsWithIndex(second)
+	if present || index != 1 || !bytes.Equal(occupant, first) {
+		t.Errorf("colliding id should find %v in slot 1, got: %v, %d, %v", first, present, index, occupant)
+	}
+	present, index, occupant = f.ContainsWithIndex(second)
+	if !present || index != 1 || !bytes.Equal(occupant, second) {
+		t.Errorf("repeated id should find itself in slot 1, got: %v, %d, %v", present, index, occupant)
+	}
+	if f.Peek(first) {
+		t.Errorf("ContainsWithIndex should insert like Contains")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	f, _ := NewFilter(1)
+	first, second := []byte{27, 28, 29}, []byte{27, 28, 30}
+	hash := f.caculateHash(first)
+	want := ContainsResult{Present: false, Index: 0, Hash: hash}
+	if got := f.Probe(first); !reflect.DeepEqual(got, want) {
+		t.Errorf("probe of a fresh id should be %+v, got: %+v", want, got)
+	}
+	want.Present = true
+	if got := f.Probe(first); !reflect.DeepEqual(got, want) {
+		t.Errorf("probe of a seen id should be %+v, got: %+v", want, got)
+	}
+	want = ContainsResult{Present: false, Index: 0, Hash: f.caculateHash(second), Evicted: first}
+	if got := f.Probe(second); !reflect.DeepEqual(got, want) {
+		t.Errorf("probe of a colliding id should be %+v, got: %+v", want, got)
+	}
+
+	g, _ := NewFilter(1024)
+	h, _ := NewFilter(1024)
+	for i := 0; i < 100; i++ {
+		id := []byte{byte(i), 1}
+		got := g.Probe(id)
+		present, index, _ := h.ContainsWithIndex(id)
+		_, hash := h.ContainsWithHash(id)
+		if got.Present != present || got.Index != index || got.Hash != hash {
+			t.Errorf("probe of %v should agree with ContainsWithIndex and ContainsWithHash, got: %+v", id, got)
+		}
+	}
+}
+
+func TestPeek(t *testing.T) {
+	f, _ := NewFilter(1024)
+	id := []byte{27, 28, 29}
+	if f.Peek(id) {
+		t.Errorf("peek should not find a fresh id")
+	}
+	if f.Count() != 0 {
+		t.Errorf("peek should not insert, count: %d", f.Count())
+	}
+	shouldNotContain(t, "peek should not have inserted", f, id)
+	if !f.Peek(id) {
+		t.Errorf("peek should find an inserted id")
+	}
+	if f.Count() != 1 {
+		t.Errorf("peek should not change the count, count: %d", f.Count())
+	}
+	f.Forget(id)
+	if f.Peek(id) {
+		t.Errorf("peek should not find a forgotten id")
+	}
+}
+
+func TestForgetEmptySlot(t *testing.T) {
+	f, _ := NewFilter(1)
+	f.Forget([]byte{27, 28, 29})
+	f.ForgetString("foo")
+	if f.Count() != 0 {
+		t.Errorf("forgetting from an empty filter should leave it empty")
+	}
+}
+
+func TestForgetReported(t *testing.T) {
+	f, _ := NewFilter(1)
+	a := []byte{27, 28, 29}
+	b := []byte{27, 28, 30}
+	if f.ForgetReported(a) {
+		t.Errorf("forgetting from an empty slot should report false")
+	}
+	f.Contains(a)
+	if f.ForgetReported(b) || !f.Peek(a) {
+		t.Errorf("forgetting an id whose slot holds another should report false")
+	}
+	if !f.ForgetReported(a) {
+		t.Errorf("forgetting a present id should report true")
+	}
+	if f.ForgetReported(a) {
+		t.Errorf("forgetting an id twice should report false")
+	}
+}
+
+func TestForgetLeavesOtherIds(t *testing.T) {
+	f, _ := NewFilter(1)
+	a := []byte{27, 28, 29}
+	b := []byte{27, 28, 30}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				f.Contains(a)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 10000; i++ {
+				f.Forget(b)
+			}
+		}()
+	}
+	wg.Wait()
+	if !f.Peek(a) {
+		t.Errorf("forgetting %v dropped %v", b, a)
+	}
+}
+
+// TestRepeatProbeAllocs is a regression guard for the steady state of a
+// filter that only sees ids it holds: hashes are pooled and indexes masked
+// in place, so only storing an id allocates.
+func TestRepeatProbeAllocs(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	skip, _ := NewFilter(1<<16, WithSkipRedundantStore())
+	id := []byte("a reasonably sized id")
+	f.Contains(id)
+	skip.Contains(id)
+	for name, probe := range map[string]func(){
+		"Peek":                   func() { f.Peek(id) },
+		"ContainsStringView":     func() { f.ContainsStringView("a reasonably sized id") },
+		"WithSkipRedundantStore": func() { skip.Contains(id) },
+		"ContainsScratch":        func() { f.ContainsScratch(id) },
+		"ContainsString":         func() { f.ContainsString("a reasonably sized id") },
+		"ForgetString of another": func() {
+			f.ForgetString("another id that is longer than the buffer strings are hashed through")
+		},
+	} {
+		if allocs := testing.AllocsPerRun(100, probe); allocs != 0 {
+			t.Errorf("%s of a held id should not allocate, allocated %v times", name, allocs)
+		}
+	}
+	// Contains stores the id again, behind a new pointer.
+	if allocs := testing.AllocsPerRun(100, func() { f.Contains(id) }); allocs > 1 {
+		t.Errorf("Contains of a held id should allocate at most once, allocated %v times", allocs)
+	}
+}
+
+func TestForgetAbsentDoesNotWrite(t *testing.T) {
+	f, _ := NewFilter(1)
+	a, b := []byte{27, 28, 29}, []byte{27, 28, 30}
+	f.Contains(a)
+	p := f.item(0).Load()
+	if allocs := testing.AllocsPerRun(100, func() { f.Forget(b) }); allocs != 0 {
+		t.Errorf("forgetting an absent id should not allocate, allocated %v times", allocs)
+	}
+	if f.item(0).Load() != p || f.Forgets() != 0 {
+		t.Errorf("forgetting an absent id should leave its slot as it was")
+	}
+}
+
+func TestEmptyId(t *testing.T) {
+	f, _ := NewFilter(1)
+	empty := []byte{}
+	shouldNotContain(t, "empty id is not the empty slot", f, empty)
+	shouldContain(t, "empty id is stored like any other", f, empty)
+	shouldContain(t, "nil is the same id as the empty one", f, nil)
+	f.Forget([]byte{1})
+	if !f.Peek(empty) {
+		t.Errorf("forgetting another id should not remove the empty id")
+	}
+	f.Forget(empty)
+	if f.Peek(empty) {
+		t.Errorf("forgotten empty id should not be mistaken for the sentinel")
+	}
+	shouldNotContain(t, "forgotten empty id", f, empty)
+	if f.Count() != 1 {
+		t.Errorf("empty id should be counted, count: %d", f.Count())
+	}
+}
+
+// TestEmptyIdNotSentinel stores empty ids, whose zero length arrays may all
+// share one address, through every way of storing an id, and checks that none
+// of them is mistaken for the forgotten slot sentinel.
+func TestEmptyIdNotSentinel(t *testing.T) {
+	store := map[string]func(f *Filter){
+		"Contains":         func(f *Filter) { f.Contains(make([]byte, 0)) },
+		"ContainsCopy":     func(f *Filter) { f.ContainsCopy([]byte{}) },
+		"ContainsString":   func(f *Filter) { f.ContainsString("") },
+		"ContainsPriority": func(f *Filter) { f.ContainsPriority(nil, 1) },
+		"Swap":             func(f *Filter) { f.Swap([]byte{2}, []byte{}) },
+		"Add":              func(f *Filter) { f.Add([]byte{1, 2}[:0]) },
+	}
+	for name, fn := range store {
+		f, _ := NewFilter(1)
+		fn(f)
+		f.Forget([]byte{1})
+		if p := f.item(0).Load(); p == nil || p == forgeted {
+			t.Errorf("%s should store the empty id, not the sentinel", name)
+		}
+		if !f.Peek(nil) || f.Count() != 1 {
+			t.Errorf("%s: forgetting another id in the bucket should not remove the empty id", name)
+		}
+		f.Forget([]byte{})
+		if f.Peek(nil) || f.item(0).Load() != forgeted || f.Count() != 0 {
+			t.Errorf("%s: forgetting the empty id should leave the sentinel", name)
+		}
+	}
+}
+
+// TestConcurrentAccess is meant to be run with the race detector.
+func TestConcurrentAccess(t *testing.T) {
+	f, _ := NewFilter(8)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				id := []byte{byte(i % 16)}
+				switch (g + i) % 4 {
+				case 0:
+					f.Contains(id)
+				case 1:
+					f.Forget(id)
+				case 2:
+					f.Peek(id)
+				case 3:
+					f.ContainsString(string(id))
+					f.ForgetString(string(id))
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if int(f.occupied.Load()) != f.Count() {
+		t.Errorf("occupied should match count %d, got: %d", f.Count(), f.occupied.Load())
+	}
+}
+
+func TestSwap(t *testing.T) {
+	f, _ := NewFilter(1024)
+	id := []byte{1, 2, 3}
+	if present, old := f.Swap(id, id); present || old != nil {
+		t.Errorf("swapping into an empty slot should return nothing, got: %v, %v", present, old)
+	}
+	if present, old := f.Swap(id, []byte{9}); !present || !bytes.Equal(old, id) {
+		t.Errorf("slot should have held %v, got: %v, %v", id, present, old)
+	}
+	if present, old := f.Swap(id, id); present || !bytes.Equal(old, []byte{9}) {
+		t.Errorf("slot should have held the replacement, got: %v, %v", present, old)
+	}
+	if !f.Peek(id) || f.Count() != 1 {
+		t.Errorf("swapped back id should be the only one contained")
+	}
+}
+
+// TestConcurrentSwap is meant to be run with the race detector. Every
+// replacement must be returned by exactly one later Swap, or still be in the
+// slot, which would not hold if Swap were a load followed by a store.
+func TestConcurrentSwap(t *testing.T) {
+	f, _ := NewFilter(1)
+	id := []byte{0, 0}
+	f.Contains(id)
+	const goroutines, swaps = 8, 1000
+	olds := make([][][]byte, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < swaps; i++ {
+				replacement := binary.BigEndian.AppendUint16(nil, uint16(1+g*swaps+i))
+				_, old := f.Swap(id, replacement)
+				olds[g] = append(olds[g], old)
+			}
+		}(g)
+	}
+	wg.Wait()
+	seen := map[string]bool{string(*f.slot(0)): true}
+	for _, o := range olds {
+		for _, old := range o {
+			if seen[string(old)] {
+				t.Fatalf("%v was swapped out twice", old)
+			}
+			seen[string(old)] = true
+		}
+	}
+	if len(seen) != goroutines*swaps+1 {
+		t.Errorf("every id should be swapped out once, saw %d of %d", len(seen), goroutines*swaps+1)
+	}
+}
+
+func TestReplaceWith(t *testing.T) {
+	f, _ := NewFilter(1024)
+	for i := 0; i < 100; i++ {
+		f.Contains([]byte{byte(i), 'f'})
+	}
+	other, _ := NewFilter(1 << 12)
+	for i := 0; i < 100; i++ {
+		other.Contains([]byte{byte(i), 'o'})
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f.Contains([]byte{byte(i), byte(g)})
+				f.Peek([]byte{byte(i), 'f'})
+				f.Forget([]byte{byte(i), byte(g)})
+			}
+		}(g)
+	}
+	if err := f.ReplaceWith(other); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	close(stop)
+	wg.Wait()
+	if f.Size() != 1<<12 {
+		t.Errorf("replaced filter should have the other's %d slots, has: %d", 1<<12, f.Size())
+	}
+	for i := 0; i < 100; i++ {
+		if id := []byte{byte(i), 'o'}; other.Peek(id) && !f.Peek(id) {
+			t.Errorf("replaced filter should hold the other's id %v", id)
+		}
+		if id := []byte{byte(i), 'f'}; f.Peek(id) {
+			t.Errorf("replaced filter should not hold its old id %v", id)
+		}
+	}
+
+	seeded, _ := NewFilterWithSeed(1024, 42)
+	crc, _ := NewFilterWithHash(1024, crc32.NewIEEE)
+	big, _ := NewFilter(1024, WithByteOrder(binary.BigEndian))
+	fingerprint, _ := NewFingerprintFilter(1024)
+	exact, _ := NewExactSizeFilter(1000)
+	for _, other := range []*Filter{seeded, crc, big, fingerprint, exact} {
+		g, _ := NewFilter(1024)
+		if err := g.ReplaceWith(other); err != ErrHashMismatch {
+			t.Errorf("replacing with a filter indexing ids differently should fail, got: %v", err)
+		}
+	}
+}
+
+func TestGrow(t *testing.T) {
+	f, _ := NewFilter(1024)
+	ids := make([][]byte, 200)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+		f.Contains(ids[i])
+	}
+	before := make([]bool, len(ids))
+	for i, id := range ids {
+		before[i] = f.Peek(id)
+	}
+	g := f
+	if err := f.Grow(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g.Size() != 2048 {
+		t.Errorf("grown filter should have 2048 slots, has: %d", g.Size())
+	}
+	for i, id := range ids {
+		if before[i] && !g.Peek(id) {
+			t.Errorf("id %v should still be contained after growing", id)
+		}
+	}
+	if int(g.occupied.Load()) != g.Count() {
+		t.Errorf("occupied should match count %d, got: %d", g.Count(), g.occupied.Load())
+	}
+	shouldNotContain(t, "fresh id after growing", g, []byte{1, 2, 3, 4, 5})
+	shouldContain(t, "seen id after growing", g, []byte{1, 2, 3, 4, 5})
+}
+
+// TestGrowConcurrent is meant to be run with the race detector.
+func TestGrowConcurrent(t *testing.T) {
+	f, _ := NewFilter(16)
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 5000; i++ {
+				id := binary.BigEndian.AppendUint32([]byte{byte(g)}, uint32(i%100))
+				f.Contains(id)
+				f.Peek(id)
+				if i%7 == 0 {
+					f.Forget(id)
+				}
+			}
+		}(g)
+	}
+	for i := 0; i < 8; i++ {
+		if err := f.Grow(); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	wg.Wait()
+	if f.Size() != 16<<8 {
+		t.Errorf("filter should have grown to %d slots, has: %d", 16<<8, f.Size())
+	}
+	id := []byte{9, 9, 9}
+	shouldNotContain(t, "fresh id after concurrent growing", f, id)
+	shouldContain(t, "seen id after concurrent growing", f, id)
+}
+
+func TestDrain(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	want := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := binary.BigEndian.AppendUint32(nil, uint32(i))
+		f.Contains(id)
+		want[string(id)] = true
+	}
+	f.Forget(binary.BigEndian.AppendUint32(nil, 0))
+	delete(want, string(binary.BigEndian.AppendUint32(nil, 0)))
+	count := f.Count()
+	drained := f.Drain()
+	if len(drained) != count {
+		t.Errorf("should drain %d ids, drained: %d", count, len(drained))
+	}
+	for _, id := range drained {
+		if !want[string(id)] {
+			t.Errorf("drained %v, which was not in the filter", id)
+		}
+	}
+	if f.Count() != 0 || f.occupied.Load() != 0 {
+		t.Errorf("drained filter should be empty, count: %d", f.Count())
+	}
+	if f.Drain() != nil {
+		t.Errorf("draining an empty filter should return nothing")
+	}
+	shouldNotContain(t, "drained id", f, drained[0])
+}
+
+func TestCompact(t *testing.T) {
+	f, _ := NewFilter(1024)
+	ids := make([][]byte, 20)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 1, 2}
+		f.Contains(ids[i])
+	}
+	f.ForgetAll(ids[:10])
+	count := f.Count()
+	f.Compact()
+	t0 := f.table.Load()
+	for i := range t0.slots {
+		if t0.slots[i].Load() == forgeted {
+			t.Fatalf("slot %d should not hold the sentinel after compacting", i)
+		}
+	}
+	n := 0
+	f.Range(func(id []byte) bool {
+		n++
+		return true
+	})
+	if n != count || f.Count() != count {
+		t.Errorf("compacting should keep the %d ids, kept: %d", count, n)
+	}
+	for _, id := range ids[10:] {
+		shouldContain(t, "id kept by compacting", f, id)
+	}
+}
+
+func TestOccupiedIndices(t *testing.T) {
+	f, _ := NewFilterForTest(64, func(id []byte) int { return int(id[0]) * 3 })
+	for _, id := range [][]byte{{5}, {1}, {9}, {2}} {
+		f.Contains(id)
+	}
+	f.Forget([]byte{9})
+	if got := fmt.Sprint(f.OccupiedIndices()); got != "[3 6 15]" {
+		t.Errorf("occupied indices should be [3 6 15], got: %s", got)
+	}
+	g, _ := NewFilter(16)
+	if g.OccupiedIndices() != nil {
+		t.Errorf("empty filter should have no occupied indices")
+	}
+}
+
+func TestBucketOccupied(t *testing.T) {
+	f, _ := NewFilterForTest(64, func(id []byte) int { return int(id[0]) * 3 })
+	f.Contains([]byte{5})
+	f.Contains([]byte{9})
+	f.Forget([]byte{9})
+	if !f.BucketOccupied(15) || !f.BucketOccupied(15+64) {
+		t.Errorf("bucket 15 should be occupied")
+	}
+	if f.BucketOccupied(14) || f.BucketOccupied(16) {
+		t.Errorf("neighbors of bucket 15 should not be occupied")
+	}
+	if f.BucketOccupied(27) || f.BucketOccupied(-1) {
+		t.Errorf("forgotten and empty buckets should not be occupied")
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	f, _ := NewFilter(1024)
+	if !f.IsEmpty() {
+		t.Errorf("fresh filter should be empty")
+	}
+	ids := make([][]byte, 100)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 1, 2}
+		f.Contains(ids[i])
+		f.Contains(ids[i])
+		if f.IsEmpty() {
+			t.Fatalf("filter should not be empty once %v was added", ids[i])
+		}
+	}
+	for _, id := range ids {
+		f.Forget(id)
+		f.Forget(id)
+	}
+	if !f.IsEmpty() || f.Count() != 0 {
+		t.Errorf("filter should be empty once every id was forgotten, count: %d", f.Count())
+	}
+	f.Contains(ids[0])
+	f.Reset()
+	if !f.IsEmpty() {
+		t.Errorf("reset filter should be empty")
+	}
+
+	g, _ := NewFilter(1)
+	g.Contains([]byte{1})
+	g.Contains([]byte{2})
+	g.Forget([]byte{2})
+	if !g.IsEmpty() {
+		t.Errorf("evicting an id should not count as another occupied slot")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	f, _ := NewFilterForTest(64, func(id []byte) int { return int(id[0]) * 3 })
+	for _, id := range [][]byte{{5, 1}, {1}, {9}, {2, 2, 2}} {
+		f.Contains(id)
+	}
+	f.Forget([]byte{9})
+	snapshot := f.Snapshot()
+	if got := fmt.Sprint(snapshot); got != "map[3:[1] 6:[2 2 2] 15:[5 1]]" {
+		t.Errorf("snapshot should map indexes to ids, got: %s", got)
+	}
+	snapshot[3][0] = 7
+	shouldContain(t, "id whose snapshot copy was modified", f, []byte{1})
+	g, _ := NewFilter(16)
+	if len(g.Snapshot()) != 0 {
+		t.Errorf("empty filter should have an empty snapshot")
+	}
+}
+
+// TestSnapshotConcurrent checks that snapshots and ranges taken during heavy
+// inserts never hold an id twice, or one that was never inserted.
+func TestSnapshotConcurrent(t *testing.T) {
+	f, _ := NewFilter(256)
+	id := func(i uint32) []byte { return binary.BigEndian.AppendUint32([]byte{'s'}, i) }
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				f.Contains(id(uint32(r.Intn(1024))))
+				if r.Intn(4) == 0 {
+					f.Forget(id(uint32(r.Intn(1024))))
+				}
+			}
+		}(w)
+	}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	valid := func(got []byte) bool {
+		return len(got) == 5 && got[0] == 's' && binary.BigEndian.Uint32(got[1:]) < 1024
+	}
+	for n := 0; n < 200; n++ {
+		seen := make(map[string]bool)
+		for index, got := range f.Snapshot() {
+			if !valid(got) || seen[string(got)] || uint64(index) != f.caculateIndex(got) {
+				t.Fatalf("snapshot holds phantom or duplicate id %v at %d", got, index)
+			}
+			seen[string(got)] = true
+		}
+		seen = make(map[string]bool)
+		f.Range(func(got []byte) bool {
+			if !valid(got) || seen[string(got)] {
+				t.Fatalf("range returned phantom or duplicate id %v", got)
+			}
+			seen[string(got)] = true
+			return true
+		})
+	}
+}
+
+func TestRangeSnapshot(t *testing.T) {
+	f, _ := NewFilterForTest(64, func(id []byte) int { return int(id[0]) })
+	bufs := make([][]byte, 20)
+	for i := range bufs {
+		bufs[i] = []byte{byte(i), 'r'}
+		f.Contains(bufs[i])
+	}
+	kept := make(map[int][]byte)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 20; i < 1000; i++ {
+			f.Contains([]byte{byte(20 + i%40), byte(i)})
+		}
+	}()
+	f.RangeSnapshot(func(index int, id []byte) bool {
+		kept[index] = id
+		return true
+	})
+	wg.Wait()
+	// The inserters reuse their buffers, which the filter still holds.
+	for _, buf := range bufs {
+		buf[1] = 'x'
+	}
+	for i := 0; i < 20; i++ {
+		if got := kept[i]; len(got) != 2 || got[0] != byte(i) || got[1] != 'r' {
+			t.Errorf("snapshot of slot %d should be a copy unaffected by later writes, got: %v", i, got)
+		}
+	}
+	for index, id := range kept {
+		if int(id[0]) != index {
+			t.Errorf("id %v should be passed with the index of its slot, got: %d", id, index)
+		}
+	}
+	kept[0][0] = 9
+	if !f.Peek([]byte{0, 'x'}) {
+		t.Errorf("modifying a snapshot copy should not change the filter")
+	}
+
+	n := 0
+	f.RangeSnapshot(func(int, []byte) bool { n++; return n < 3 })
+	if n != 3 {
+		t.Errorf("RangeSnapshot should stop when fn returns false, called %d times", n)
+	}
+}
+
+func TestReset(t *testing.T) {
+	f, _ := NewFilter(1024)
+	ids := make([][]byte, 100)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 1, 2}
+		f.Contains(ids[i])
+	}
+	f.Reset()
+	for _, id := range ids {
+		shouldNotContain(t, "reset should empty the filter", f, id)
+	}
+}
+
+func TestResetConcurrent(t *testing.T) {
+	f, _ := NewFilter(1 << 12)
+	before := make([][]byte, 500)
+	for i := range before {
+		before[i] = []byte{byte(i >> 8), byte(i), 'b'}
+		f.Contains(before[i])
+	}
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				id := []byte{byte(w), byte(i >> 8), byte(i), 'c'}
+				f.Contains(id)
+				if i%3 == 0 {
+					f.Forget(id)
+				}
+			}
+		}(w)
+	}
+	for i := 0; i < 20; i++ {
+		f.Reset()
+	}
+	for _, id := range before {
+		if f.Peek(id) {
+			t.Errorf("reset should remove id %v that was in the filter before it", id)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if err := f.Validate(); err != nil {
+		t.Errorf("filter should be valid after concurrent resets, got: %s", err)
+	}
+	if int(f.occupied.Load()) != f.Count() {
+		t.Errorf("occupied should match count %d, got: %d", f.Count(), f.occupied.Load())
+	}
+	f.Reset()
+	if !f.IsEmpty() || f.Count() != 0 {
+		t.Errorf("reset without concurrent inserts should empty the filter, count: %d", f.Count())
+	}
+}
+
+func TestCount(t *testing.T) {
+	f, _ := NewFilter(1 << 20)
+	if f.Count() != 0 {
+		t.Errorf("new filter should be empty, count: %d", f.Count())
+	}
+	const k = 1000
+	for i := 0; i < k; i++ {
+		f.Contains([]byte{byte(i >> 8), byte(i), 3})
+	}
+	// Collisions may evict a few ids, but with 1000 ids in 2^20 slots only a
+	// handful are expected.
+	if c := f.Count(); c > k || c < k-10 {
+		t.Errorf("count should be close to %d, got: %d", k, c)
+	}
+	f.Forget([]byte{0, 0, 3})
+	if c := f.Count(); c > k-1 || c < k-11 {
+		t.Errorf("forgotten ids should not be counted, got: %d", c)
+	}
+	if int(f.occupied.Load()) != f.Count() {
+		t.Errorf("occupied should match count %d, got: %d", f.Count(), f.occupied.Load())
+	}
+}
+
+func TestStringMethods(t *testing.T) {
+	byteFilter, _ := NewFilter(4)
+	stringFilter, _ := NewFilter(4)
+	viewFilter, _ := NewFilter(4)
+	// long is hashed in more than one chunk by the string methods.
+	long := strings.Repeat("long id ", 20)
+	ids := []string{"foo", "bar", "foo", "", "baz", "bar", "", "quux", "foo", long, long}
+	for i, id := range ids {
+		if i == 5 {
+			byteFilter.Forget([]byte("foo"))
+			stringFilter.ForgetString("foo")
+			viewFilter.ForgetString("foo")
+		}
+		want := byteFilter.Contains([]byte(id))
+		if got := stringFilter.ContainsString(id); got != want {
+			t.Errorf("ContainsString(%q) = %v, Contains returned %v", id, got, want)
+		}
+		if got := viewFilter.ContainsStringView(id); got != want {
+			t.Errorf("ContainsStringView(%q) = %v, Contains returned %v", id, got, want)
+		}
+	}
+	if !stringFilter.Peek([]byte(long)) {
+		t.Errorf("ContainsString should put a long id where Peek finds it")
+	}
+}
+
+func TestContainsOwned(t *testing.T) {
+	// A minimal buffer pool, whose buffers are told apart by address.
+	var pool [][]byte
+	get := func(s string) []byte {
+		if len(pool) == 0 {
+			return []byte(s)
+		}
+		buf := pool[len(pool)-1]
+		pool = pool[:len(pool)-1]
+		return append(buf[:0], s...)
+	}
+	f, _ := NewFilter(1, WithCopyOnInsert())
+	f.OnEvict(func(evicted, inserted []byte) { pool = append(pool, evicted) })
+
+	a := get("first")
+	if f.ContainsOwned(a) {
+		t.Errorf("fresh id should not be contained")
+	}
+	if stored := *f.slot(0); &stored[0] != &a[0] {
+		t.Errorf("owned id should be stored without a copy")
+	}
+	again := get("first")
+	if !f.ContainsOwned(again) || &(*f.slot(0))[0] != &a[0] {
+		t.Errorf("id already held should be reported and its owned buffer kept")
+	}
+	// The filter has a single slot, so a different id evicts a.
+	if f.ContainsOwned(get("second")) {
+		t.Errorf("different id should not be contained")
+	}
+	if len(pool) != 1 || &pool[0][0] != &a[0] {
+		t.Fatalf("evicted buffer should be given back to the pool, pool: %q", pool)
+	}
+	if reused := get("third"); &reused[0] != &a[0] || f.ContainsOwned(reused) {
+		t.Errorf("buffer given back should be reusable for a new id")
+	}
+	if !f.Peek([]byte("third")) || f.Peek([]byte("first")) {
+		t.Errorf("filter should hold the id stored in the reused buffer")
+	}
+
+	fp, _ := NewFingerprintFilter(16)
+	id := []byte("fingerprinted")
+	if fp.ContainsOwned(id) || !fp.ContainsOwned(id) || !fp.Peek(id) {
+		t.Errorf("fingerprint filter should treat an owned id like any other")
+	}
+}
+
+func TestFirstSeen(t *testing.T) {
+	f, _ := NewFilter(1024)
+	var seen []string
+	onFirst := func(id []byte) { seen = append(seen, string(id)) }
+	for _, id := range []string{"a", "b", "a", "a", "c", "b"} {
+		f.FirstSeen([]byte(id), onFirst)
+	}
+	if got := strings.Join(seen, " "); got != "a b c" {
+		t.Errorf("callback should fire once for each new id, fired for: %s", got)
+	}
+
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f.FirstSeen([]byte("shared"), func([]byte) { calls.Add(1) })
+		}()
+	}
+	wg.Wait()
+	if n := calls.Load(); n != 1 {
+		t.Errorf("callback should fire once for an id added concurrently, fired %d times", n)
+	}
+}
+
+func TestContainsOrLoad(t *testing.T) {
+	f, _ := NewFilter(1024)
+	id := []byte("job 1")
+	fail := errors.New("processing failed")
+	loads := 0
+	load := func(err error) func([]byte) error {
+		return func(got []byte) error {
+			if string(got) != string(id) {
+				t.Errorf("load should be called with the id, got: %q", got)
+			}
+			loads++
+			return err
+		}
+	}
+	if present, err := f.ContainsOrLoad(id, load(fail)); present || err != fail {
+		t.Errorf("failed load should return its error, got: %v, %v", present, err)
+	}
+	if f.Peek(id) || f.Count() != 0 {
+		t.Errorf("id whose load failed should not be seen")
+	}
+	if present, err := f.ContainsOrLoad(id, load(nil)); present || err != nil {
+		t.Errorf("retried id should be new, got: %v, %v", present, err)
+	}
+	if present, err := f.ContainsOrLoad(id, load(fail)); !present || err != nil {
+		t.Errorf("loaded id should be contained without loading it again, got: %v, %v", present, err)
+	}
+	if loads != 2 {
+		t.Errorf("load should be called for new ids only, called %d times", loads)
+	}
+}
+
+func TestContainsFold(t *testing.T) {
+	f, _ := NewFilter(1024)
+	if f.ContainsFold("Foo") {
+		t.Errorf("fresh id should not be contained")
+	}
+	if !f.ContainsFold("foo") || !f.ContainsFold("FOO") || f.Count() != 1 {
+		t.Errorf("ids differing only in case should map to the same entry, count: %d", f.Count())
+	}
+	if f.ContainsFold("bar") {
+		t.Errorf("different id should not be contained")
+	}
+	if !f.Peek([]byte("foo")) || f.Peek([]byte("Foo")) {
+		t.Errorf("filter should store the folded form of the id")
+	}
+	f.ForgetFold("fOO")
+	if f.Peek([]byte("foo")) || !f.Peek([]byte("bar")) {
+		t.Errorf("ForgetFold should forget the id whatever its case")
+	}
+}
+
+func benchmarkRepeatedString(b *testing.B, contains func(f *Filter, id string) bool) {
+	f, _ := NewFilter(1 << 16)
+	ids := make([]string, 1024)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("a reasonably sized id %d", i)
+		contains(f, ids[i])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		contains(f, ids[i%len(ids)])
+	}
+}
+
+func BenchmarkContainsString(b *testing.B) {
+	benchmarkRepeatedString(b, (*Filter).ContainsString)
+}
+
+func BenchmarkContainsStringView(b *testing.B) {
+	benchmarkRepeatedString(b, (*Filter).ContainsStringView)
+}
+
+func TestClone(t *testing.T) {
+	f, _ := NewFilter(1024)
+	for i := 0; i < 100; i++ {
+		f.Contains([]byte{byte(i), 4, 5})
+	}
+	f.Forget([]byte{0, 4, 5})
+	c := f.Clone()
+	if c.Count() != f.Count() {
+		t.Errorf("clone count should be %d, got: %d", f.Count(), c.Count())
+	}
+	shouldNotContain(t, "forgotten ids should stay forgotten in the clone", c, []byte{0, 4, 5})
+	shouldContain(t, "clone should hold the original ids", c, []byte{1, 4, 5})
+	shouldNotContain(t, "new id in the clone", c, []byte{200, 4, 5})
+	if f.Peek([]byte{200, 4, 5}) {
+		t.Errorf("insert into the clone should not appear in the original")
+	}
+	if !f.Peek([]byte{1, 4, 5}) {
+		t.Errorf("original should keep its ids")
+	}
+}
+
+// TestSharedSentinel checks that a forgotten slot copied to another filter is
+// still empty there, as every filter marks forgotten slots with the same
+// sentinel.
+func TestSharedSentinel(t *testing.T) {
+	f, _ := NewFilterForTest(4, func([]byte) int { return 1 })
+	id := []byte{27, 28, 29}
+	f.Contains(id)
+	f.Forget(id)
+	for _, g := range []*Filter{f, f.Clone()} {
+		if g.table.Load().slots[1].Load() != forgeted {
+			t.Fatalf("forgotten slot should hold the sentinel")
+		}
+		if g.slot(1) != nil || g.Count() != 0 || g.occupied.Load() != 0 || g.Peek(id) {
+			t.Errorf("sentinel slot should be empty")
+		}
+	}
+	data, _ := f.Clone().MarshalBinary()
+	var g Filter
+	if err := g.UnmarshalBinary(data); err != nil || g.Count() != 0 {
+		t.Errorf("sentinel slot should be marshaled as empty, count: %d, err: %v", g.Count(), err)
+	}
+}
+
+func TestLoadFactor(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	if f.LoadFactor() != 0 {
+		t.Errorf("empty filter should have a load factor of 0, got: %f", f.LoadFactor())
+	}
+	for i := 0; i < 1<<12; i++ {
+		f.Contains([]byte{byte(i >> 8), byte(i), 6})
+	}
+	// 4096 ids in 65536 slots should lose only a hundred or so to collisions.
+	if lf := f.LoadFactor(); lf > 1.0/16 || lf < 0.06 {
+		t.Errorf("load factor should be close to 1/16, got: %f", lf)
+	}
+	full, _ := NewFilter(1)
+	full.Contains([]byte{1})
+	if full.LoadFactor() != 1 {
+		t.Errorf("full filter should have a load factor of 1, got: %f", full.LoadFactor())
+	}
+}
+
+func TestMemoryBytes(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	ptr := int(unsafe.Sizeof(uintptr(0)))
+	if m := f.MemoryBytes(); m != 1<<16*ptr {
+		t.Errorf("empty filter should take a pointer per slot, %d bytes, got: %d", 1<<16*ptr, m)
+	}
+	for i := 0; i < 1000; i++ {
+		f.Contains(append(make([]byte, 98), byte(i>>8), byte(i)))
+	}
+	// 1000 ids of 100 bytes, a few lost to collisions, with headers of 3 words.
+	min, max := 1<<16*ptr+990*(100+3*ptr), 1<<16*ptr+1000*(100+3*ptr)
+	if m := f.MemoryBytes(); m < min || m > max {
+		t.Errorf("estimate should be between %d and %d bytes, got: %d", min, max, m)
+	}
+	c, _ := NewFilterWithCollisionStats(1 << 16)
+	if m := c.MemoryBytes(); m != 1<<16*(ptr+4) {
+		t.Errorf("collision counts should take 4 bytes per slot, got: %d", m)
+	}
+}
+
+func TestArrayBytes(t *testing.T) {
+	f, _ := NewFilter(1000)
+	ptr := unsafe.Sizeof(uintptr(0))
+	if got := f.ArrayBytes(); got != 1024*ptr {
+		t.Errorf("array of 1024 slots should take %d bytes, got: %d", 1024*ptr, got)
+	}
+	f.Contains([]byte("an id"))
+	if got := f.ArrayBytes(); got != 1024*ptr {
+		t.Errorf("stored ids should not count towards the array, got: %d", got)
+	}
+	f.Grow()
+	if got := f.ArrayBytes(); got != 2048*ptr {
+		t.Errorf("grown array should take %d bytes, got: %d", 2048*ptr, got)
+	}
+}
+
+func TestLengthHistogram(t *testing.T) {
+	f, _ := NewFilterForTest(64, func(id []byte) int { return int(id[0]) })
+	for i, n := range []int{1, 3, 3, 10, 10, 10, 200} {
+		id := make([]byte, n)
+		id[0] = byte(i)
+		f.Contains(id)
+	}
+	f.Contains([]byte{20, 1})
+	f.Forget([]byte{20, 1})
+	if got := fmt.Sprint(f.LengthHistogram()); got != "map[1:1 3:2 10:3 200:1]" {
+		t.Errorf("histogram should count the ids of each length, got: %s", got)
+	}
+	g, _ := NewFilter(16)
+	if len(g.LengthHistogram()) != 0 {
+		t.Errorf("empty filter should have an empty histogram")
+	}
+}
+
+func TestFalseNegativeRate(t *testing.T) {
+	f, _ := NewFilter(1024)
+	last := f.FalseNegativeRate()
+	if last != 0 {
+		t.Errorf("empty filter should have a rate of 0, got: %f", last)
+	}
+	for i := 0; i < 2048; i++ {
+		f.Contains([]byte{byte(i >> 8), byte(i), 7})
+		rate := f.FalseNegativeRate()
+		if rate < last {
+			t.Fatalf("rate should not drop as ids are inserted, went from %f to %f", last, rate)
+		}
+		last = rate
+	}
+	if last <= 0.5 || last > 1 {
+		t.Errorf("rate should be high after inserting 2048 ids into 1024 slots, got: %f", last)
+	}
+}
+
+func TestResize(t *testing.T) {
+	f, _ := NewFilterWithSeed(64, 7)
+	for i := 0; i < 256; i++ {
+		f.Contains([]byte{byte(i), 8})
+	}
+	g, err := f.Resize(1 << 16)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g.Size() != 1<<16 || f.Size() != 64 {
+		t.Errorf("resized filter should have %d slots and the original 64, got: %d and %d", 1<<16, g.Size(), f.Size())
+	}
+	if g.Count() != f.Count() {
+		t.Errorf("resized filter should hold all %d ids, got: %d", f.Count(), g.Count())
+	}
+	for i := 0; i < f.Size(); i++ {
+		p := f.slot(uint64(i))
+		if p != nil && !g.Peek(*p) {
+			t.Errorf("resized filter lost %v", *p)
+		}
+	}
+	if !bytes.Equal(g.seed, f.seed) {
+		t.Errorf("resized filter should keep the seed")
+	}
+	if _, err := f.Resize(0); err != ErrSizeTooSmall {
+		t.Errorf("resize should fail like NewFilter, got: %v", err)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	f, _ := NewFilterWithSeed(1<<16, 7)
+	for i := 0; i < 1000; i++ {
+		f.Contains(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	g, err := f.Shrink(3000)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if g.Size() != 4096 || f.Size() != 1<<16 {
+		t.Errorf("shrunk filter should have 4096 slots and the original %d, got: %d and %d", 1<<16, g.Size(), f.Size())
+	}
+	kept := 0
+	f.Range(func(id []byte) bool {
+		if g.Peek(id) {
+			kept++
+		}
+		return true
+	})
+	// About 1000*(1-(1-1/4096)^1000)/2 ids are expected to collide.
+	if kept < 850 || kept != g.Count() {
+		t.Errorf("shrunk filter should keep most of the ids, kept %d of %d, holds: %d", kept, f.Count(), g.Count())
+	}
+	if !bytes.Equal(g.seed, f.seed) {
+		t.Errorf("shrunk filter should keep the seed")
+	}
+	for _, size := range []int{1 << 16, 40000, 1 << 20} {
+		if _, err := f.Shrink(size); err != ErrSizeNotSmaller {
+			t.Errorf("shrinking to %d should fail with ErrSizeNotSmaller, got: %v", size, err)
+		}
+	}
+	if _, err := f.Shrink(0); err != ErrSizeTooSmall {
+		t.Errorf("shrink should fail like NewFilter, got: %v", err)
+	}
+	exact, _ := NewExactSizeFilter(1000)
+	if g, err := exact.Shrink(999); err != nil || g.Size() != 999 {
+		t.Errorf("exact size filter should shrink to 999 slots, got: %v", err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	want := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id := []byte{byte(i), 9}
+		f.Contains(id)
+		want[string(id)] = true
+	}
+	f.Forget([]byte{0, 9})
+	delete(want, string([]byte{0, 9}))
+	got := map[string]bool{}
+	f.Range(func(id []byte) bool {
+		got[string(id)] = true
+		return true
+	})
+	if len(got) != len(want) {
+		t.Errorf("range should visit %d ids, visited: %d", len(want), len(got))
+	}
+	for id := range want {
+		if !got[id] {
+			t.Errorf("range did not visit %v", []byte(id))
+		}
+	}
+	visited := 0
+	f.Range(func(id []byte) bool {
+		visited++
+		return visited < 3
+	})
+	if visited != 3 {
+		t.Errorf("range should stop when fn returns false, visited: %d", visited)
+	}
+}
+
+func TestString(t *testing.T) {
+	f, _ := NewFilter(1024)
+	for i := 0; i < 37; i++ {
+		f.Contains([]byte{byte(i), 10})
+	}
+	if s := f.String(); !strings.Contains(s, "size=1024") {
+		t.Errorf("string should contain the size, got: %s", s)
+	}
+	empty, _ := NewFilter(8)
+	if s := fmt.Sprint(empty); s != "oppobloom.Filter{size=8, count=0, load=0.000}" {
+		t.Errorf("unexpected summary of an empty filter: %s", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	f, _ := NewFilter(1024)
+	g, _ := NewFilterWithCollisionStats(16)
+	h, _ := NewFingerprintFilter(16)
+	for _, filter := range []*Filter{f, g, h} {
+		filter.Contains([]byte{1, 2, 3})
+		if err := filter.Validate(); err != nil {
+			t.Errorf("filter from a constructor should be valid, got: %s", err)
+		}
+	}
+
+	var zero Filter
+	if err := zero.Validate(); !errors.Is(err, ErrCorruptFilter) {
+		t.Errorf("zero filter should be corrupt, got: %v", err)
+	}
+	if err := zero.UnmarshalBinary([]byte{snapshotVersion, 3}); err != ErrInvalidSnapshot {
+		t.Fatalf("snapshot of 3 slots should be rejected, got: %v", err)
+	}
+	data, _ := f.MarshalBinary()
+	if err := zero.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := zero.Validate(); err != nil {
+		t.Errorf("unmarshaled filter should be valid, got: %s", err)
+	}
+
+	for name, corrupt := range map[string]func(*Filter){
+		"mask": func(f *Filter) { f.table.Load().mask = 1 },
+		"size": func(f *Filter) {
+			t := f.table.Load()
+			t.slots = t.slots[:3]
+		},
+		"collisions": func(f *Filter) { f.table.Load().collisions = make([]atomic.Uint32, 2) },
+		"occupied":   func(f *Filter) { f.occupied.Store(-1) },
+		"hash":       func(f *Filter) { f.hashes = nil },
+	} {
+		var c Filter
+		c.UnmarshalBinary(data)
+		corrupt(&c)
+		if err := c.Validate(); !errors.Is(err, ErrCorruptFilter) {
+			t.Errorf("filter with a corrupt %s should be flagged, got: %v", name, err)
+		}
+	}
+}
+
+func TestExactSizeFilter(t *testing.T) {
+	for _, size := range []int{1, 3, 1000, 1025} {
+		f, err := NewExactSizeFilter(size)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if f.Size() != size {
+			t.Errorf("exact size filter should have %d slots, has: %d", size, f.Size())
+		}
+		used := make(map[int]bool)
+		for i := 0; i < 10*size; i++ {
+			id := binary.BigEndian.AppendUint32(nil, uint32(i))
+			_, index, _ := f.ContainsWithIndex(id)
+			if index < 0 || index >= size {
+				t.Fatalf("index %d of %v is out of the range of %d slots", index, id, size)
+			}
+			used[index] = true
+		}
+		if len(used) != size {
+			t.Errorf("ids should reach all %d slots, reached: %d", size, len(used))
+		}
+		if err := f.Validate(); err != nil {
+			t.Errorf("exact size filter should be valid, got: %s", err)
+		}
+	}
+
+	f, _ := NewExactSizeFilter(1025)
+	id := []byte{27, 28, 29}
+	shouldNotContain(t, "fresh id in an exact size filter", f, id)
+	shouldContain(t, "seen id in an exact size filter", f, id)
+	f.ContainsUint64(42)
+	if !f.ContainsString(string(id)) || !f.PeekUint64(42) {
+		t.Errorf("string and integer ids should work in an exact size filter")
+	}
+	f.Forget(id)
+	shouldNotContain(t, "forgotten id in an exact size filter", f, id)
+
+	data, _ := f.MarshalBinary()
+	g, _ := NewExactSizeFilter(1)
+	if err := g.UnmarshalBinary(data); err != nil || g.Size() != 1025 || !Equal(f, g) {
+		t.Errorf("snapshot should restore the exact size filter, got: %v, size %d", err, g.Size())
+	}
+	var plain Filter
+	if err := plain.UnmarshalBinary(data); err != ErrInvalidSnapshot {
+		t.Errorf("snapshot of 1025 slots should not restore a rounded filter, got: %v", err)
+	}
+	if err := f.Grow(); err != nil || f.Size() != 2050 {
+		t.Errorf("exact size filter should double to 2050 slots, got: %v, size %d", err, f.Size())
+	}
+	shouldContain(t, "id seen before Grow in an exact size filter", f, id)
+}
+
 func TestSizeRounding(t *testing.T) {
 	f, _ := NewFilter(3)
 	if f.Size() != 4 {
-		t.Errorf("3 should round to 4, rounded to: ", f.Size())
+		t.Errorf("3 should round to 4, rounded to: %d", f.Size())
 	}
 	f, _ = NewFilter(4)
 	if f.Size() != 4 {
-		t.Errorf("4 should round to 4", f.Size())
+		t.Errorf("4 should round to 4, rounded to: %d", f.Size())
 	}
 	f, _ = NewFilter(129)
 	if f.Size() != 256 {
-		t.Errorf("129 should round to 256", f.Size())
+		t.Errorf("129 should round to 256, rounded to: %d", f.Size())
+	}
+}
+
+func TestRoundedSize(t *testing.T) {
+	for size, want := range map[int]int{
+		1: 1, 2: 2, 3: 4, 4: 4, 5: 8, 1000: 1024, 1024: 1024, 1025: 2048,
+		0: 0, -1: 0, maxFilterSize: maxFilterSize, maxFilterSize + 1: 0,
+	} {
+		if got := RoundedSize(size); got != want {
+			t.Errorf("RoundedSize(%d) should be %d, got: %d", size, want, got)
+		}
+	}
+}
+
+// TestIndexBeyond32Bits checks that indexes use the full 64-bit digest fold,
+// without allocating a filter large enough to need them.
+func TestIndexBeyond32Bits(t *testing.T) {
+	if bits.UintSize < 64 {
+		if _, err := NewFilter(1<<28 + 1); err != ErrSizeTooLarge {
+			t.Errorf("sizes beyond 2^28 should be too large on 32-bit platforms")
+		}
+		return
+	}
+	f, _ := NewFilter(1)
+	f.table.Store(&table{slots: f.table.Load().slots, mask: maxFilterSize - 1})
+	high := false
+	id := make([]byte, 8)
+	for i := 0; i < 1000; i++ {
+		binary.LittleEndian.PutUint64(id, uint64(i))
+		index := f.caculateIndex(id)
+		if index > f.mask() {
+			t.Fatalf("index %d is beyond the mask %d", index, f.mask())
+		}
+		if index >= 1<<39 {
+			high = true
+		}
+	}
+	if !high {
+		t.Errorf("no index used the high bit of a 2^40 mask")
+	}
+}
+
+func TestRecommendSize(t *testing.T) {
+	for _, c := range []struct {
+		items int
+		rate  float64
+	}{{1, 0.5}, {100, 0.1}, {1000, 0.01}, {5000, 0.2}, {20000, 0.05}} {
+		size, err := RecommendSize(c.items, c.rate)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if size&(size-1) != 0 {
+			t.Errorf("recommended size %d is not a power of two", size)
+		}
+		if size > 1 && expectedFalseNegativeRate(c.items, size/2) <= c.rate {
+			t.Errorf("%d slots are enough for %d ids at rate %f, recommended: %d", size/2, c.items, c.rate, size)
+		}
+		f, _ := NewFilter(size)
+		for i := 0; i < c.items; i++ {
+			f.Add(binary.BigEndian.AppendUint32([]byte{1}, uint32(i)))
+		}
+		// Allow for the variance of a single run.
+		if got := f.FalseNegativeRate(); got > c.rate*1.1 {
+			t.Errorf("%d ids in %d slots should have a rate of at most %f, got: %f", c.items, size, c.rate, got)
+		}
+	}
+}
+
+func TestNewFilterForRate(t *testing.T) {
+	const items, rate = 5000, 0.05
+	f, err := NewFilterForRate(items, rate)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want, _ := RecommendSize(items, rate); f.Size() != want {
+		t.Errorf("filter should have the recommended %d slots, has: %d", want, f.Size())
+	}
+	for i := 0; i < items; i++ {
+		f.Add(binary.BigEndian.AppendUint32([]byte{2}, uint32(i)))
+	}
+	// Count the new ids that would evict one of the ids added.
+	evicting := 0
+	const probes = 20000
+	for i := 0; i < probes; i++ {
+		if f.slot(f.caculateIndex(binary.BigEndian.AppendUint32([]byte{3}, uint32(i)))) != nil {
+			evicting++
+		}
+	}
+	if got := float64(evicting) / probes; got > rate*1.1 {
+		t.Errorf("new ids should evict at a rate of at most %f, got: %f", rate, got)
+	}
+
+	if _, err := NewFilterForRate(0, rate); err != ErrSizeTooSmall {
+		t.Errorf("did not error out on zero items, got: %v", err)
+	}
+	if _, err := NewFilterForRate(items, 1); err != ErrInvalidRate {
+		t.Errorf("did not error out on rate 1, got: %v", err)
+	}
+	if _, err := NewFilterForRate(maxFilterSize, 0.01); err != ErrSizeTooLarge {
+		t.Errorf("did not error out on too many items, got: %v", err)
+	}
+}
+
+func TestCollisionProbability(t *testing.T) {
+	for _, c := range []struct {
+		n, size int
+		want    float64
+	}{
+		{0, 1, 0},
+		{1, 1, 1},
+		{1, 2, 0.5},
+		{2, 2, 0.75},
+		{1, 4, 0.25},
+		{3, 4, 37.0 / 64},
+		{2, 10, 0.19},
+	} {
+		got, err := CollisionProbability(c.n, c.size)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if math.Abs(got-c.want) > 1e-12 {
+			t.Errorf("CollisionProbability(%d, %d) should be %f, got: %f", c.n, c.size, c.want, got)
+		}
+	}
+	for _, c := range []struct {
+		n, size int
+		err     error
+	}{{0, 0, ErrSizeTooSmall}, {1, -4, ErrSizeTooSmall}, {-1, 4, ErrInvalidCount}, {5, 4, ErrInvalidCount}} {
+		if _, err := CollisionProbability(c.n, c.size); err != c.err {
+			t.Errorf("CollisionProbability(%d, %d) should fail with %v, got: %v", c.n, c.size, c.err, err)
+		}
+	}
+}
+
+func TestRecommendSizeInvalid(t *testing.T) {
+	if _, err := RecommendSize(0, 0.1); err != ErrSizeTooSmall {
+		t.Errorf("did not error out on zero items")
+	}
+	for _, rate := range []float64{0, 1, -0.5, 2, math.NaN()} {
+		if _, err := RecommendSize(10, rate); err != ErrInvalidRate {
+			t.Errorf("did not error out on rate %f", rate)
+		}
+	}
+	if _, err := RecommendSize(maxFilterSize, 0.01); err != ErrSizeTooLarge {
+		t.Errorf("did not error out on too many items")
+	}
+}
+
+func TestNewFilterFrom(t *testing.T) {
+	ids := make([][]byte, 1000)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+	}
+	f, err := NewFilterFrom(1<<20, ids)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	missing := 0
+	for _, id := range ids {
+		if !f.Peek(id) {
+			missing++
+		}
+	}
+	// About 1000*1000/2^21 seeded ids are expected to collide.
+	if missing > 5 {
+		t.Errorf("%d of %d seeded ids are missing", missing, len(ids))
+	}
+	shouldContain(t, "seeded id", f, ids[999])
+	if _, err := NewFilterFrom(0, ids); err != ErrSizeTooSmall {
+		t.Errorf("did not error out on a zero size")
+	}
+}
+
+func TestNewFilterFromParallel(t *testing.T) {
+	ids := make([][]byte, 1000)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+	}
+	for _, workers := range []int{0, 1, 4, 2000} {
+		f, err := NewFilterFromParallel(1<<20, ids, workers)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		missing := 0
+		for _, id := range ids {
+			if !f.Peek(id) {
+				missing++
+			}
+		}
+		if missing > 5 || int(f.occupied.Load()) != f.Count() {
+			t.Errorf("%d workers: %d of %d seeded ids are missing, count: %d", workers, missing, len(ids), f.Count())
+		}
+	}
+	if f, err := NewFilterFromParallel(16, nil, 4); err != nil || f.Count() != 0 {
+		t.Errorf("no ids should make an empty filter, got: %v", err)
+	}
+	if _, err := NewFilterFromParallel(0, ids, 4); err != ErrSizeTooSmall {
+		t.Errorf("did not error out on a zero size")
+	}
+}
+
+func TestRoundedSizeSweep(t *testing.T) {
+	for size := 1; size <= 1<<20; size++ {
+		got := RoundedSize(size)
+		if got&(got-1) != 0 || got < size || got >= 2*size {
+			t.Fatalf("RoundedSize(%d) should be the next power of two, got: %d", size, got)
+		}
+	}
+	// Allocating every size up to 1<<20 is too slow, so only check filters
+	// around each power of two.
+	for p := 1; p <= 1<<20; p *= 2 {
+		for _, size := range []int{p - 1, p, p + 1} {
+			if size <= 0 {
+				continue
+			}
+			f, err := NewFilter(size)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if f.Size()&(f.Size()-1) != 0 || f.mask() != uint64(f.Size()-1) {
+				t.Errorf("NewFilter(%d) has size %d and mask %d", size, f.Size(), f.mask())
+			}
+		}
+	}
+}
+
+func TestCapacityAt(t *testing.T) {
+	for _, load := range []float64{0.01, 0.1, 0.5, 0.9} {
+		f, _ := NewFilter(1 << 14)
+		n := f.CapacityAt(load)
+		if n <= 0 || expectedFalseNegativeRate(n+1, f.Size()) <= load {
+			t.Errorf("capacity at %f should be the most ids within it, got: %d", load, n)
+		}
+		for i := 0; i < n; i++ {
+			f.Add(binary.BigEndian.AppendUint32(nil, uint32(i)))
+		}
+		// Allow for the variance of a single run.
+		if got := f.LoadFactor(); got > load*1.05 {
+			t.Errorf("%d ids should load the filter to at most %f, got: %f", n, load, got)
+		}
+	}
+	f, _ := NewFilter(16)
+	if n := f.CapacityAt(1); n != math.MaxInt {
+		t.Errorf("full load should take any number of ids, got: %d", n)
+	}
+	for _, load := range []float64{0, -0.5, 1.5, math.NaN()} {
+		if n := f.CapacityAt(load); n != 0 {
+			t.Errorf("invalid load %f should have no capacity, got: %d", load, n)
+		}
+	}
+}
+
+func TestNewFilterForCapacity(t *testing.T) {
+	for _, c := range []struct {
+		n       int
+		maxLoad float64
+		want    int
+	}{
+		{1, 0.5, 2}, {512, 0.5, 1024}, {513, 0.5, 2048}, {1000, 0.5, 2048},
+		{1024, 1, 1024}, {1025, 1, 2048}, {768, 0.75, 1024}, {769, 0.75, 2048},
+	} {
+		f, err := NewFilterForCapacity(c.n, c.maxLoad)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if f.Size() != c.want {
+			t.Errorf("filter for %d ids at %v should have %d slots, has: %d", c.n, c.maxLoad, c.want, f.Size())
+		}
+	}
+	if _, err := NewFilterForCapacity(0, 0.5); err != ErrSizeTooSmall {
+		t.Errorf("did not error out on a zero capacity")
+	}
+	if _, err := NewFilterForCapacity(maxFilterSize, 0.5); err != ErrSizeTooLarge {
+		t.Errorf("did not error out on a too-large capacity")
+	}
+	for _, maxLoad := range []float64{0, -0.5, 1.5, math.NaN()} {
+		if _, err := NewFilterForCapacity(10, maxLoad); err != ErrInvalidLoadFactor {
+			t.Errorf("load %v should fail with ErrInvalidLoadFactor, got: %v", maxLoad, err)
+		}
+	}
+}
+
+// TestSizeNearIntLimit checks that sizes too large for the platform fail with
+// ErrSizeTooLarge instead of overflowing int or panicking in make.
+func TestSizeNearIntLimit(t *testing.T) {
+	sizes := []int{math.MaxInt, math.MaxInt - 1, math.MaxInt/2 + 1, maxFilterSize + 1}
+	if bits.UintSize == 32 {
+		sizes = append(sizes, 1<<28+1, 1<<30, 1<<31-2)
+	}
+	for _, size := range sizes {
+		if f, err := NewFilter(size); err != ErrSizeTooLarge || f != nil {
+			t.Errorf("NewFilter(%d) should fail with ErrSizeTooLarge, got: %v", size, err)
+		}
+		if got := RoundedSize(size); got != 0 {
+			t.Errorf("RoundedSize(%d) should be 0, got: %d", size, got)
+		}
+	}
+	if _, err := NewFilterForCapacity(math.MaxInt, 0.5); err != ErrSizeTooLarge {
+		t.Errorf("did not error out on a capacity near the int limit")
+	}
+	if _, err := RecommendSize(math.MaxInt, 0.5); err != ErrSizeTooLarge {
+		t.Errorf("did not error out on items near the int limit")
 	}
 }
 
 func TestTooLargeSize(t *testing.T) {
-	size := (1 << 30) + 1
+	size := maxFilterSize + 1
 	f, err := NewFilter(size)
 	if err != ErrSizeTooLarge {
 		t.Errorf("did not error out on a too-large filter size")
@@ -55,6 +2009,34 @@ func TestTooLargeSize(t *testing.T) {
 	}
 }
 
+func TestCappedSize(t *testing.T) {
+	f, err := NewFilterCapped(16, 16)
+	if err != nil || f.Size() != 16 {
+		t.Errorf("16 should fit a cap of 16, err: %v", err)
+	}
+	f, err = NewFilterCapped(17, 16)
+	if err != ErrSizeTooLarge || f != nil {
+		t.Errorf("did not error out on a size above the cap")
+	}
+	f, err = NewFilterCapped(5, 6)
+	if err != ErrSizeTooLarge || f != nil {
+		t.Errorf("did not error out on a size rounding above the cap")
+	}
+	f, err = NewFilterCapped(maxFilterSize+1, math.MaxInt)
+	if err != ErrSizeTooLarge || f != nil {
+		t.Errorf("cap should not raise the platform max")
+	}
+}
+
+func TestMaxFilterSizeIgnored(t *testing.T) {
+	old := MaxFilterSize
+	defer func() { MaxFilterSize = old }()
+	MaxFilterSize = 1
+	if _, err := NewFilter(4); err != nil {
+		t.Errorf("NewFilter should not be limited by MaxFilterSize, err: %s", err)
+	}
+}
+
 func TestTooSmallSize(t *testing.T) {
 	f, err := NewFilter(0)
 	if err != ErrSizeTooSmall {
@@ -65,9 +2047,159 @@ func TestTooSmallSize(t *testing.T) {
 	}
 }
 
+// TestDistribution checks that ids are spread evenly over the whole filter,
+// not just over the low buckets.
+func TestDistribution(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	checkDistribution(t, f)
+}
+
+func checkDistribution(t *testing.T, f *Filter) {
+	t.Helper()
+	const keys = 8192
+	const groups = 64
+	counts := make([]int, groups)
+	id := make([]byte, 4)
+	for i := 0; i < keys; i++ {
+		binary.BigEndian.PutUint32(id, uint32(i))
+		counts[int(f.caculateIndex(id))*groups/f.Size()]++
+	}
+	expected := float64(keys) / groups
+	chiSquare := 0.0
+	for _, c := range counts {
+		d := float64(c) - expected
+		chiSquare += d * d / expected
+	}
+	// The 99.9th percentile of chi-square with 63 degrees of freedom is
+	// about 103.
+	if chiSquare > 103 {
+		t.Errorf("ids are not uniformly distributed, chi-square: %f, counts: %v", chiSquare, counts)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	a, _ := NewFilterWithSeed(1<<16, 1)
+	b, _ := NewFilterWithSeed(1<<16, 2)
+	unseeded, _ := NewFilter(1 << 16)
+	zero, _ := NewFilterWithSeed(1<<16, 0)
+	same := 0
+	id := make([]byte, 4)
+	for i := 0; i < 1000; i++ {
+		binary.BigEndian.PutUint32(id, uint32(i))
+		if a.caculateIndex(id) == b.caculateIndex(id) {
+			same++
+		}
+		if zero.caculateIndex(id) != unseeded.caculateIndex(id) {
+			t.Errorf("a zero seed should index like NewFilter")
+		}
+	}
+	// About 1000/2^16 ids are expected to land in the same bucket by chance.
+	if same > 5 {
+		t.Errorf("%d of 1000 ids had the same index with different seeds", same)
+	}
+	shouldNotContain(t, "fresh id in a seeded filter", a, id)
+	shouldContain(t, "seen id in a seeded filter", a, id)
+}
+
+// TestFullDigestFold compares collisions of the default fold, which XORs all
+// four words of the MD5 digest, with those of the fold the filter used to
+// have, which only mixed the first three bytes. The full fold is always used,
+// so there is no option to choose it.
+func TestFullDigestFold(t *testing.T) {
+	const size = 1 << 16
+	threeBytes := func(sum [md5.Size]byte) uint32 {
+		x := uint32(sum[0])
+		for _, val := range sum[1:3] {
+			x = x<<3 + uint32(val)
+		}
+		return x
+	}
+	f, _ := NewFilter(size)
+	full, truncated := make(map[uint64]bool), make(map[uint64]bool)
+	id := make([]byte, 4)
+	const keys = 8192
+	for i := 0; i < keys; i++ {
+		binary.BigEndian.PutUint32(id, uint32(i))
+		full[f.caculateIndex(id)] = true
+		truncated[uint64(threeBytes(md5.Sum(id))&(size-1))] = true
+	}
+	fullCollisions, truncatedCollisions := keys-len(full), keys-len(truncated)
+	// About keys^2/(2*size) = 512 ids collide with a uniform fold.
+	if fullCollisions > 600 || fullCollisions*2 > truncatedCollisions {
+		t.Errorf("full fold should collide far less than the three byte fold, collided %d and %d times", fullCollisions, truncatedCollisions)
+	}
+}
+
+func TestCustomHash(t *testing.T) {
+	for _, h := range []func() hash.Hash32{fnv.New32a, crc32.NewIEEE} {
+		f, err := NewFilterWithHash(1024, h)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		id := []byte{27, 28, 29}
+		shouldNotContain(t, "fresh filter with custom hash", f, id)
+		shouldContain(t, "second lookup with custom hash", f, id)
+		f.Forget(id)
+		shouldNotContain(t, "forgotten with custom hash", f, id)
+	}
+}
+
+func TestHash64(t *testing.T) {
+	f, err := NewFilterWithHash64(1<<16, fnv.New64a)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	checkDistribution(t, f)
+	id := []byte{27, 28, 29}
+	h := fnv.New64a()
+	h.Write(id)
+	if index := f.caculateIndex(id); index != h.Sum64()&f.mask() {
+		t.Errorf("index should be the masked Sum64 %d, got: %d", h.Sum64()&f.mask(), index)
+	}
+	shouldNotContain(t, "fresh filter with a 64-bit hash", f, id)
+	shouldContain(t, "second lookup with a 64-bit hash", f, id)
+	if _, err := NewFilterWithHash64(2, nil); err != ErrNilHash {
+		t.Errorf("did not error out on a nil 64-bit hash function")
+	}
+}
+
+func TestPanickingHash(t *testing.T) {
+	panicking := func() hash.Hash32 {
+		panic("crypto/md5: use of MD5 is not allowed in FIPS 140-only mode")
+	}
+	f, err := NewFilterWithHash(2, panicking)
+	if !errors.Is(err, ErrHashUnavailable) {
+		t.Errorf("did not error out on a panicking hash function, got: %v", err)
+	}
+	if f != nil {
+		t.Errorf("did not return nil on a panicking hash function")
+	}
+}
+
+func TestNilHash(t *testing.T) {
+	f, err := NewFilterWithHash(2, nil)
+	if err != ErrNilHash {
+		t.Errorf("did not error out on a nil hash function")
+	}
+	if f != nil {
+		t.Errorf("did not return nil on a nil hash function")
+	}
+}
+
+// builder returns a function that unwraps the results of a constructor,
+// failing t on an error, as in build(NewFilter(1024)).
+func builder(t *testing.T) func(f *Filter, err error) *Filter {
+	return func(f *Filter, err error) *Filter {
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return f
+	}
+}
+
 func shouldContain(t *testing.T, msg string, f *Filter, id []byte) {
 	if !f.Contains(id) {
-		t.Errorf("should contain, %s: id %v, array: %v", msg, id, f.array)
+		t.Errorf("should contain, %s: id %v, array: %v", msg, id, f.table.Load().slots)
 	}
 }
 
@@ -76,3 +2208,178 @@ func shouldNotContain(t *testing.T, msg string, f *Filter, id []byte) {
 		t.Errorf("should not contain, %s: %v", msg, id)
 	}
 }
+
+func benchmarkContainsBatch(b *testing.B, contains func(f *Filter, ids [][]byte) []bool) {
+	ids := make([][]byte, 1<<20)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f, _ := NewFilter(1 << 21)
+		contains(f, ids)
+	}
+}
+
+func benchmarkSeeding(b *testing.B, build func(ids [][]byte) (*Filter, error)) {
+	ids := make([][]byte, 1<<20)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		build(ids)
+	}
+}
+
+func BenchmarkNewFilterFrom(b *testing.B) {
+	benchmarkSeeding(b, func(ids [][]byte) (*Filter, error) { return NewFilterFrom(1<<21, ids) })
+}
+
+func BenchmarkNewFilterFromParallel(b *testing.B) {
+	benchmarkSeeding(b, func(ids [][]byte) (*Filter, error) { return NewFilterFromParallel(1<<21, ids, 0) })
+}
+
+// benchmarkIDs returns n distinct ids of 12 to 27 bytes.
+func benchmarkIDs(n int) [][]byte {
+	ids := make([][]byte, n)
+	for i := range ids {
+		ids[i] = fmt.Appendf(nil, "id-%d-%s", i, strings.Repeat("x", i%16))
+	}
+	return ids
+}
+
+// BenchmarkContainsHit looks up ids already in a filter of a million slots.
+func BenchmarkContainsHit(b *testing.B) {
+	f, _ := NewFilter(1 << 20)
+	ids := benchmarkIDs(1 << 12)
+	for _, id := range ids {
+		f.Contains(id)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Contains(ids[i&(1<<12-1)])
+	}
+}
+
+// BenchmarkContainsMiss looks up ids never seen before, each inserted.
+func BenchmarkContainsMiss(b *testing.B) {
+	f, _ := NewFilter(1 << 20)
+	id := make([]byte, 0, 16)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		id = binary.BigEndian.AppendUint64(id[:0], uint64(i))
+		f.Contains(id[:len(id):len(id)])
+	}
+}
+
+// BenchmarkContainsParallel looks up a mix of seen and new ids from
+// GOMAXPROCS goroutines.
+func BenchmarkContainsParallel(b *testing.B) {
+	f, _ := NewFilter(1 << 20)
+	ids := benchmarkIDs(1 << 16)
+	var next atomic.Uint64
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f.Contains(ids[next.Add(1)&(1<<16-1)])
+		}
+	})
+}
+
+func BenchmarkContainsAll(b *testing.B) {
+	benchmarkContainsBatch(b, (*Filter).ContainsAll)
+}
+
+func BenchmarkContainsAllParallel(b *testing.B) {
+	benchmarkContainsBatch(b, func(f *Filter, ids [][]byte) []bool {
+		return f.ContainsAllParallel(ids, 0)
+	})
+}
+
+// BenchmarkForgetAbsent forgets ids that are not in a full filter from many
+// goroutines, which should only load slots, never write them.
+func BenchmarkForgetAbsent(b *testing.B) {
+	f, _ := NewFilter(1 << 10)
+	for i := 0; i < 1<<12; i++ {
+		f.Contains(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	var next atomic.Uint32
+	b.ReportAllocs()
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		id := make([]byte, 5)
+		for pb.Next() {
+			binary.BigEndian.PutUint32(id, next.Add(1))
+			f.Forget(id)
+		}
+	})
+	if n := f.Forgets(); n != 0 {
+		b.Errorf("absent ids should not be forgotten, forgot %d", n)
+	}
+}
+
+func BenchmarkCaculateIndex(b *testing.B) {
+	f, _ := NewFilter(1 << 16)
+	id := []byte("a reasonably sized id")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.caculateIndex(id)
+	}
+}
+
+func BenchmarkCaculateIndexSeeded(b *testing.B) {
+	f, _ := NewFilterWithSeed(1<<16, 42)
+	id := []byte("a reasonably sized id")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.caculateIndex(id)
+	}
+}
+
+// FuzzFilter applies the operations encoded in data, a byte for the operation
+// followed by one for the id, 0 being the nil id, to a small filter and checks
+// every result against a model of its slots: Contains and Peek find an id
+// exactly when its slot last stored it and no Forget of it came after.
+func FuzzFilter(f *testing.F) {
+	f.Add([]byte{0, 1, 0, 1, 2, 1, 0, 1, 1, 1})
+	f.Add([]byte{0, 1, 0, 2, 1, 1, 2, 2, 0, 0, 3, 0})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter, _ := NewFilter(4)
+		model := make(map[uint64][]byte)
+		for ; len(data) >= 2; data = data[2:] {
+			id := data[1:2]
+			if data[1] == 0 {
+				id = nil
+			}
+			index := filter.caculateIndex(id)
+			stored, ok := model[index]
+			held := ok && bytes.Equal(stored, id)
+			switch data[0] % 4 {
+			case 0:
+				if got := filter.Contains(id); got != held {
+					t.Fatalf("Contains(%v) = %v, want %v", id, got, held)
+				}
+				model[index] = id
+			case 1:
+				if got := filter.Peek(id); got != held {
+					t.Fatalf("Peek(%v) = %v, want %v", id, got, held)
+				}
+			case 2:
+				filter.Forget(id)
+				if held {
+					delete(model, index)
+				}
+			case 3:
+				filter.Compact()
+			}
+		}
+		if err := filter.Validate(); err != nil {
+			t.Fatalf("filter should be valid, got: %s", err)
+		}
+		if filter.Count() != len(model) || int(filter.occupied.Load()) != len(model) {
+			t.Fatalf("filter should hold %d ids, count: %d, occupied: %d", len(model), filter.Count(), filter.occupied.Load())
+		}
+	})
+}
@@ -0,0 +1,79 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package oppobloomtest provides helpers for testing code built on oppobloom.
+package oppobloomtest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+
+	"github.com/nvcnvn/oppobloom"
+)
+
+// StressTest runs opsPerGoroutine random Contains, Forget and Peek calls on f
+// from each of goroutines goroutines at once, then checks f with Validate and
+// that its Stats counted no more inserts than there were Contains calls, as
+// filters made with WithSampleRate or WithRejectEmpty skip some on purpose. It
+// returns the first panic or violation found, or nil. The calls are drawn from
+// math/rand with fixed seeds, so each goroutine makes the same calls on every
+// run, though their interleaving varies. It does not time out: run it under go
+// test -timeout to catch a deadlock.
+func StressTest(f *oppobloom.Filter, goroutines, opsPerGoroutine int) error {
+	if goroutines <= 0 || opsPerGoroutine < 0 {
+		return fmt.Errorf("oppobloomtest: need a positive number of goroutines and ops, got %d and %d", goroutines, opsPerGoroutine)
+	}
+	// Drawing ids from twice as many as there are slots makes goroutines
+	// race on the same slots and ids, and evict each other's ids.
+	keys := int64(2 * f.Size())
+	before := f.Stats().Inserts
+	var mu sync.Mutex
+	var contains uint64
+	errs := make([]error, goroutines)
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					errs[g] = fmt.Errorf("oppobloomtest: goroutine %d panicked: %v", g, r)
+				}
+			}()
+			r := rand.New(rand.NewSource(int64(g) + 1))
+			id := make([]byte, 8)
+			n := uint64(0)
+			for i := 0; i < opsPerGoroutine; i++ {
+				binary.BigEndian.PutUint64(id, uint64(r.Int63n(keys)))
+				switch r.Intn(4) {
+				case 0, 1:
+					f.Contains(append([]byte(nil), id...))
+					n++
+				case 2:
+					f.Forget(id)
+				case 3:
+					f.Peek(id)
+				}
+			}
+			mu.Lock()
+			contains += n
+			mu.Unlock()
+		}(g)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if err := f.Validate(); err != nil {
+		return fmt.Errorf("oppobloomtest: invariants broken after stress: %w", err)
+	}
+	if inserts := f.Stats().Inserts - before; inserts > contains {
+		return fmt.Errorf("oppobloomtest: %d inserts counted for %d calls to Contains", inserts, contains)
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloomtest
+
+import (
+	"testing"
+
+	"github.com/nvcnvn/oppobloom"
+)
+
+func TestStressTest(t *testing.T) {
+	for _, opts := range [][]oppobloom.Option{
+		nil,
+		{oppobloom.WithSkipRedundantStore()},
+		{oppobloom.WithAutoGrow(0.5)},
+		{oppobloom.WithSampleRate(0.5, 1)},
+		{oppobloom.WithRejectEmpty()},
+	} {
+		f, _ := oppobloom.NewFilter(256, opts...)
+		if err := StressTest(f, 8, 2000); err != nil {
+			t.Errorf("stress test should pass, got: %s", err)
+		}
+	}
+	f, _ := oppobloom.NewFilter(256)
+	if err := StressTest(f, 0, 10); err == nil {
+		t.Errorf("did not error out on zero goroutines")
+	}
+}
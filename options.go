@@ -0,0 +1,352 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidLoadFactor = errors.New("oppobloom: load factor must be greater than 0 and at most 1")
+var ErrNilClock = errors.New("oppobloom: clock cannot be nil")
+var ErrNilObserver = errors.New("oppobloom: observer cannot be nil")
+var ErrInvalidRateLimit = errors.New("oppobloom: rate limit must be positive")
+var ErrNilByteOrder = errors.New("oppobloom: byte order cannot be nil")
+var ErrSizeBelowMin = errors.New("oppobloom: size given rounds below the minimum size")
+var ErrInvalidRetries = errors.New("oppobloom: retry cap must be positive")
+var ErrInvalidSampleRate = errors.New("oppobloom: sample rate must be between 0 and 1")
+var ErrInvalidIndexBits = errors.New("oppobloom: index bits must be 16, 32 or 64")
+var ErrIndexBitsTooFew = errors.New("oppobloom: too few index bits for the filter's size")
+var ErrNilNotify = errors.New("oppobloom: notify cannot be nil")
+var ErrNilWriter = errors.New("oppobloom: writer cannot be nil")
+var ErrInvalidLogCapacity = errors.New("oppobloom: eviction log capacity must be positive")
+
+// An Option configures a filter when it is built.
+type Option func(*Filter) error
+
+// WithMaxLoadFactor makes ContainsCtx return ErrFilterSaturated instead of
+// inserting while more than max of the filter's slots are occupied, which
+// lets callers grow the filter or shed load. Contains is not affected.
+func WithMaxLoadFactor(max float64) Option {
+	return func(f *Filter) error {
+		if !(max > 0 && max <= 1) {
+			return ErrInvalidLoadFactor
+		}
+		f.maxLoad = max
+		return nil
+	}
+}
+
+// WithAutoGrow makes the filter Grow whenever an insert leaves more than
+// maxLoad of its slots occupied. The inserting goroutine grows the filter
+// while the others carry on with the old slots, and inserts that cross the
+// threshold while it grows don't grow it again. See Grow for what concurrent
+// inserts and forgets see meanwhile.
+func WithAutoGrow(maxLoad float64) Option {
+	return func(f *Filter) error {
+		if !(maxLoad > 0 && maxLoad <= 1) {
+			return ErrInvalidLoadFactor
+		}
+		f.autoGrow = maxLoad
+		return nil
+	}
+}
+
+// WithExactSize makes the filter keep the size passed to the constructor as
+// it is, instead of rounding it up to a power of two, as NewExactSizeFilter
+// does for NewFilter, so that e.g. a 100 slot filter doesn't take 128. See
+// NewExactSizeFilter for what that costs. Options that check the size, such as
+// WithMinSize, see the exact size only if they come after it.
+func WithExactSize() Option {
+	return func(f *Filter) error {
+		if !f.exact {
+			f.exact = true
+			f.table.Store(f.newTable(f.requested))
+		}
+		return nil
+	}
+}
+
+// WithMinSize makes the constructor return ErrSizeBelowMin if the filter would
+// have fewer than min slots, after rounding, rejecting filters so small that
+// nearly every id collides. Without it any positive size is accepted.
+func WithMinSize(min int) Option {
+	return func(f *Filter) error {
+		if f.Size() < min {
+			return ErrSizeBelowMin
+		}
+		return nil
+	}
+}
+
+// WithSkipRedundantStore makes Contains load an id's slot first and leave it
+// alone if it already holds the id, instead of always storing the id. That
+// costs an extra load for new ids but saves the store, and the contention it
+// causes between cores, when the same ids are looked up over and over. Results
+// are the same either way.
+func WithSkipRedundantStore() Option {
+	return func(f *Filter) error {
+		f.skipRedundant = true
+		return nil
+	}
+}
+
+// WithByteOrder makes the filter decode the words of MD5 digests in order when
+// folding them into indexes, for the default hash and fingerprints. The
+// default is binary.LittleEndian. Digests are decoded with order rather than
+// the host's byte order, so a filter computes the same indexes, and can load
+// the same snapshots, on every platform. It has no effect on the indexes of
+// hashes passed to NewFilterWithHash.
+func WithByteOrder(order binary.ByteOrder) Option {
+	return func(f *Filter) error {
+		if order == nil {
+			return ErrNilByteOrder
+		}
+		f.order = order
+		h := f.hashes.Get()
+		f.hashes.Put(h)
+		if _, ok := h.(*md5UintHash); ok {
+			f.newHash = newMD5UintHashOrder(order)
+			f.hashes = newHashPool(f.newHash)
+		}
+		return nil
+	}
+}
+
+// WithClock makes the filter tell the time with now instead of time.Now, e.g.
+// to control the expiry of a TTLFilter in tests.
+func WithClock(now func() time.Time) Option {
+	return func(f *Filter) error {
+		if now == nil {
+			return ErrNilClock
+		}
+		f.now = now
+		return nil
+	}
+}
+
+// WithCopyOnInsert makes the filter store a copy of each id it inserts rather
+// than the id itself, so callers may reuse the id's buffer afterwards, e.g.
+// the slice returned by bufio.Scanner.Bytes. Without it, changing an id after
+// passing it to the filter changes the stored id too.
+func WithCopyOnInsert() Option {
+	return func(f *Filter) error {
+		f.copyOnInsert = true
+		return nil
+	}
+}
+
+// WithObserver makes the filter call obs with the duration of every call to
+// Contains, Forget, ContainsAll, ContainsAllParallel, ContainsOrLoad and
+// ForgetAll, named "contains", "forget", "contains_all",
+// "contains_all_parallel", "contains_or_load" and "forget_all". The duration
+// of ContainsOrLoad includes that of load. Durations are measured with the
+// filter's clock. obs is called on the calling goroutine and must be safe for
+// concurrent use. Without an observer the filter doesn't tell the time.
+func WithObserver(obs func(op string, d time.Duration)) Option {
+	return func(f *Filter) error {
+		if obs == nil {
+			return ErrNilObserver
+		}
+		f.observer = obs
+		return nil
+	}
+}
+
+// WithForgetRateLimit makes the filter remove at most maxPerSecond ids per
+// second of its clock, as a safety valve against a bug forgetting every id.
+// Forgets beyond the limit leave the id in the filter, ForgetReported returns
+// false for them, and Stats counts them as Limited. Forgets of ids that are
+// not in the filter don't count towards the limit.
+func WithForgetRateLimit(maxPerSecond int) Option {
+	return func(f *Filter) error {
+		if maxPerSecond <= 0 {
+			return ErrInvalidRateLimit
+		}
+		f.maxForgets = int64(maxPerSecond)
+		return nil
+	}
+}
+
+// allowForget returns whether another id may be forgotten this second under
+// WithForgetRateLimit, counting it if so.
+func (f *Filter) allowForget() bool {
+	now := f.now().Unix()
+	if second := f.forgetSecond.Load(); second != now && f.forgetSecond.CompareAndSwap(second, now) {
+		f.forgetCount.Store(0)
+	}
+	if f.forgetCount.Add(1) > f.maxForgets {
+		f.limited.Add(1)
+		return false
+	}
+	return true
+}
+
+// WithMaxCASRetries makes ContainsE give up with ErrContention, rather than
+// keep retrying, once n attempts to swap an id into its slot failed because
+// other goroutines changed the slot in between. It makes pathological
+// contention visible instead of hanging the caller. Other methods keep
+// retrying until they succeed.
+func WithMaxCASRetries(n int) Option {
+	return func(f *Filter) error {
+		if n <= 0 {
+			return ErrInvalidRetries
+		}
+		f.maxCASRetries = n
+		return nil
+	}
+}
+
+// WithAdaptiveHash makes the filter index ids of up to 8 bytes by mixing
+// their bits with splitmix64, as ContainsUint64 does, instead of hashing them
+// with its hash, for which a short id is mostly overhead. Longer ids are
+// hashed as before. Ids are stored and compared the same either way; only
+// their slots differ from a filter without the option. Short ids mixed this
+// way can be crafted to collide, even with a seed, so the option should not be
+// used for ids chosen by an adversary.
+func WithAdaptiveHash() Option {
+	return func(f *Filter) error {
+		f.adaptive = true
+		return nil
+	}
+}
+
+// WithInterning makes the filter store one shared copy of each distinct id, so
+// that an id inserted again and again, into one slot or several, takes its
+// bytes once. The copies are shared with filters made from this one, e.g. by
+// Clone or Resize. Like WithCopyOnInsert, callers may reuse an id's buffer
+// afterwards. The copies are kept in a sync.Map from the id, which only ever
+// grows, even as ids are evicted or forgotten, so the option suits workloads
+// that repeat a bounded set of ids.
+func WithInterning() Option {
+	return func(f *Filter) error {
+		f.interned = new(sync.Map)
+		return nil
+	}
+}
+
+// WithRejectEmpty makes the filter refuse nil and empty ids, which by default
+// are one id stored like any other: Contains and its variants return false
+// for them without storing anything, so Peek never finds them and Forget has
+// nothing to remove, and ContainsE returns ErrEmptyID as it always does. It
+// has no effect on fingerprint filters, whose fingerprints are never empty.
+func WithRejectEmpty() Option {
+	return func(f *Filter) error {
+		f.rejectEmpty = true
+		return nil
+	}
+}
+
+// WithEvictionLog makes the filter keep the last capacity evictions, as
+// returned by EvictionLog, e.g. to see which ids were pushed out before a
+// false negative. Older evictions are overwritten. Logging an eviction takes
+// no locks, so the log barely slows inserts. The log is not copied by Clone or
+// Resize; the filters they make keep their own.
+func WithEvictionLog(capacity int) Option {
+	return func(f *Filter) error {
+		if capacity <= 0 {
+			return ErrInvalidLogCapacity
+		}
+		f.evictionLogSize = capacity
+		return nil
+	}
+}
+
+// WithAuditLog makes the filter write every id it stores that was not already
+// in it to w, as its length in uvarint encoding followed by its bytes, as for a
+// record of all the ids it ever saw. That covers every insert, including the
+// replacement of Swap and the inserts of a TwoWayFilter. An id that was evicted
+// or forgotten is written again when it is inserted again, as the filter can no
+// longer tell it saw it. Ids a filter gets without inserting them, from a
+// snapshot restored by UnmarshalBinary or the slots of another filter taken by
+// ReplaceWith, are not written. NewFingerprintFilter rejects WithAuditLog, as
+// its filters only store digests. Ids are written synchronously, one at a time,
+// so a slow w slows inserts; wrap it in a bufio.Writer, flushed by the caller,
+// to batch writes. Write errors are counted by AuditErrors. Like the eviction
+// log, the audit log is not copied by Clone or Resize.
+func WithAuditLog(w io.Writer) Option {
+	return func(f *Filter) error {
+		if w == nil {
+			return ErrNilWriter
+		}
+		f.auditLog = &auditLog{w: w}
+		return nil
+	}
+}
+
+// WithLoadThresholds makes the filter call notify with each of thresholds,
+// load factors as from LoadFactor, when an insert makes the load reach it from
+// below. notify is not called for a threshold again until a forget or Reset
+// takes the load below it, or an insert finds it below, as after Grow, so it
+// is called once per crossing rather than for every insert above it.
+// notify is called on the inserting goroutine and must be safe for concurrent
+// use. Like callbacks, thresholds are not copied by Clone or Resize.
+func WithLoadThresholds(thresholds []float64, notify func(crossed float64)) Option {
+	return func(f *Filter) error {
+		if notify == nil {
+			return ErrNilNotify
+		}
+		for _, level := range thresholds {
+			if !(level > 0 && level <= 1) {
+				return ErrInvalidLoadFactor
+			}
+		}
+		f.thresholds = &loadThresholds{
+			levels:  append([]float64(nil), thresholds...),
+			crossed: make([]atomic.Bool, len(thresholds)),
+			notify:  notify,
+		}
+		return nil
+	}
+}
+
+// WithSampleRate makes Contains, and so Add, insert only about rate of the ids
+// passed to it, to keep the filter sparse on streams so busy that catching the
+// most frequent duplicates is enough. So do ContainsAll, ContainsAllParallel,
+// ContainsOrLoad, NewFilterFrom and NewFilterFromParallel. An id that is not
+// picked is looked up as by Peek, so Contains still returns true for it if it
+// is in the filter: a duplicate is caught once any earlier occurrence of it was
+// picked and not evicted since. rate must be between 0, which inserts nothing,
+// and 1, the default, which inserts every id. The other methods that insert ids
+// always insert them.
+//
+// Ids are picked by a SplitMix64 generator seeded with seed, which takes the
+// next number for every id, so two filters given the same seed and the same
+// calls in the same order pick the same ids. Under concurrent use the order of
+// the calls, and so which ids are picked, depends on scheduling.
+func WithSampleRate(rate float64, seed uint64) Option {
+	return func(f *Filter) error {
+		if !(rate >= 0 && rate <= 1) {
+			return ErrInvalidSampleRate
+		}
+		f.skipRate = 1 - rate
+		f.sampleSeed = seed
+		return nil
+	}
+}
+
+// WithIndexBits makes the filter fold the hash of each id into n bits, 16, 32
+// or 64, the default, before reducing it to an index. The hash is computed in
+// full either way, so n doesn't make filters faster, but it fixes which of
+// its bits are used, e.g. to index like a filter built with a 32-bit hash, as
+// oppobloom once did. The constructor returns ErrIndexBitsTooFew if the
+// filter has more than 2^n slots, as some would never be used, and Grow
+// returns ErrSizeTooLarge instead of growing past 2^n.
+func WithIndexBits(n int) Option {
+	return func(f *Filter) error {
+		switch {
+		case n != 16 && n != 32 && n != 64:
+			return ErrInvalidIndexBits
+		case n < 64 && f.Size() > 1<<n:
+			return ErrIndexBitsTooFew
+		}
+		f.indexBits = n
+		return nil
+	}
+}
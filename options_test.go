@@ -0,0 +1,703 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithMaxLoadFactor(t *testing.T) {
+	f, err := NewFilter(4, WithMaxLoadFactor(0.5))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ctx := context.Background()
+	for i := 0; f.Count() <= 2; i++ {
+		if _, err := f.ContainsCtx(ctx, []byte{byte(i)}); err != nil {
+			t.Fatalf("filter below its maximum load should not error, got: %s", err)
+		}
+	}
+	present, err := f.ContainsCtx(ctx, []byte{255, 255})
+	if err != ErrFilterSaturated || present {
+		t.Errorf("saturated filter should return ErrFilterSaturated, got: %v, %v", present, err)
+	}
+	if f.Peek([]byte{255, 255}) {
+		t.Errorf("saturated filter should not insert")
+	}
+	f.Reset()
+	if _, err := f.ContainsCtx(ctx, []byte{255, 255}); err != nil {
+		t.Errorf("reset filter should not be saturated, got: %s", err)
+	}
+}
+
+func TestInvalidMaxLoadFactor(t *testing.T) {
+	for _, max := range []float64{0, -1, 1.5} {
+		f, err := NewFilter(4, WithMaxLoadFactor(max))
+		if err != ErrInvalidLoadFactor || f != nil {
+			t.Errorf("did not error out on a maximum load factor of %f", max)
+		}
+	}
+}
+
+func TestContainsCtxCanceled(t *testing.T) {
+	f, _ := NewFilter(4)
+	ctx, cancel := context.WithCancel(context.Background())
+	if present, err := f.ContainsCtx(ctx, []byte{1}); present || err != nil {
+		t.Errorf("live context should behave like Contains, got: %v, %v", present, err)
+	}
+	cancel()
+	if _, err := f.ContainsCtx(ctx, []byte{1}); err != context.Canceled {
+		t.Errorf("canceled context should return its error, got: %v", err)
+	}
+	if present, err := f.ContainsCtx(context.Background(), []byte{1}); !present || err != nil {
+		t.Errorf("id should be contained, got: %v, %v", present, err)
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	calls := 0
+	clock := func() time.Time {
+		calls++
+		return time.Unix(0, 0)
+	}
+	f, err := NewTTLFilter(4, time.Second, WithClock(clock))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f.Contains([]byte{1})
+	if calls != 1 {
+		t.Errorf("filter should tell the time with the clock, called %d times", calls)
+	}
+	if g, err := NewFilter(4, WithClock(nil)); err != ErrNilClock || g != nil {
+		t.Errorf("did not error out on a nil clock")
+	}
+}
+
+func TestWithCopyOnInsert(t *testing.T) {
+	f, _ := NewFilter(1024, WithCopyOnInsert())
+	plain, _ := NewFilter(1024)
+	buf := []byte{27, 28, 29}
+	f.Contains(buf)
+	plain.Contains(buf)
+	buf[2] = 30
+	if !f.Peek([]byte{27, 28, 29}) {
+		t.Errorf("copied id should not change with the caller's buffer")
+	}
+	if f.Peek(buf) {
+		t.Errorf("changed buffer should not be contained")
+	}
+	if plain.Peek([]byte{27, 28, 29}) {
+		t.Errorf("uncopied id should change with the caller's buffer")
+	}
+	if !f.Clone().copyOnInsert {
+		t.Errorf("clone should keep copying on insert")
+	}
+}
+
+func TestWithObserver(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	tick := func() time.Time {
+		clock.advance(time.Millisecond)
+		return clock.now()
+	}
+	var ops []string
+	f, err := NewFilter(1024, WithClock(tick), WithObserver(func(op string, d time.Duration) {
+		if d != time.Millisecond {
+			t.Errorf("%s should take a tick, took: %s", op, d)
+		}
+		ops = append(ops, op)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f.Contains([]byte{1})
+	f.Forget([]byte{1})
+	f.ContainsAll([][]byte{{1}, {2}})
+	f.ForgetAll([][]byte{{1}, {2}})
+	want := []string{"contains", "forget", "contains_all", "forget_all"}
+	if fmt.Sprint(ops) != fmt.Sprint(want) {
+		t.Errorf("observed ops should be %v, got: %v", want, ops)
+	}
+
+	calls := 0
+	g, _ := NewFilter(1024, WithClock(func() time.Time {
+		calls++
+		return time.Unix(0, 0)
+	}))
+	g.Contains([]byte{1})
+	g.Forget([]byte{1})
+	if calls != 0 {
+		t.Errorf("filter without an observer should not tell the time, called %d times", calls)
+	}
+	if g, err := NewFilter(4, WithObserver(nil)); err != ErrNilObserver || g != nil {
+		t.Errorf("did not error out on a nil observer")
+	}
+}
+
+func TestWithAutoGrow(t *testing.T) {
+	// Every id gets its own slot, so only growing could lose one.
+	f, err := NewFilterForTest(16, func(id []byte) int { return int(binary.BigEndian.Uint32(id)) }, WithAutoGrow(0.5))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var ids [][]byte
+	for i := 0; i < 100; i++ {
+		id := binary.BigEndian.AppendUint32(nil, uint32(i))
+		ids = append(ids, id)
+		if f.Contains(id) {
+			t.Errorf("fresh id %v should not be contained", id)
+		}
+		if load := f.LoadFactor(); load > 0.5 {
+			t.Fatalf("filter should have grown at a load of 0.5, load: %f", load)
+		}
+	}
+	if f.Size() != 256 {
+		t.Errorf("100 ids should grow the filter to 256 slots, has: %d", f.Size())
+	}
+	for _, id := range ids {
+		if !f.Peek(id) {
+			t.Errorf("id %v should still be contained after growing", id)
+		}
+	}
+	if g, err := NewFilter(4, WithAutoGrow(0)); err != ErrInvalidLoadFactor || g != nil {
+		t.Errorf("did not error out on a zero load factor")
+	}
+}
+
+// TestAutoGrowConcurrent is meant to be run with the race detector.
+func TestAutoGrowConcurrent(t *testing.T) {
+	f, _ := NewFilter(16, WithAutoGrow(0.75))
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				f.Contains(binary.BigEndian.AppendUint32([]byte{byte(g)}, uint32(i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	if f.Size() < 4000 {
+		t.Errorf("4000 ids should have grown the filter past 4000 slots, has: %d", f.Size())
+	}
+}
+
+func TestWithForgetRateLimit(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	f, err := NewFilter(1<<16, WithClock(clock.now), WithForgetRateLimit(10))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	ids := make([][]byte, 30)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+		f.Contains(ids[i])
+	}
+	f.Forget([]byte{1, 2, 3})
+	removed := 0
+	for _, id := range ids[:20] {
+		if f.ForgetReported(id) {
+			removed++
+		}
+	}
+	if removed != 10 || f.Stats().Limited != 10 {
+		t.Errorf("10 of 20 forgets should be allowed in a second, allowed: %d, stats: %+v", removed, f.Stats())
+	}
+	if !f.Peek(ids[19]) {
+		t.Errorf("id over the limit should still be contained")
+	}
+	clock.advance(time.Second)
+	f.ForgetAll(ids[10:20])
+	f.ForgetString(string(ids[20]))
+	if c := f.Count(); c != 10 || !f.Peek(ids[20]) {
+		t.Errorf("the limit should start over every second, count: %d", c)
+	}
+	if g, err := NewFilter(4, WithForgetRateLimit(0)); err != ErrInvalidRateLimit || g != nil {
+		t.Errorf("did not error out on a zero rate limit")
+	}
+}
+
+// TestForgetRateLimitConcurrent is meant to be run with the race detector.
+func TestForgetRateLimitConcurrent(t *testing.T) {
+	f, _ := NewFilter(1<<16, WithClock(func() time.Time { return time.Unix(0, 0) }), WithForgetRateLimit(100))
+	for i := 0; i < 1000; i++ {
+		f.Contains(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := g; i < 1000; i += 4 {
+				f.Forget(binary.BigEndian.AppendUint32(nil, uint32(i)))
+			}
+		}(g)
+	}
+	wg.Wait()
+	if s := f.Stats(); s.Forgets != 100 {
+		t.Errorf("only 100 forgets should be allowed, stats: %+v", s)
+	}
+}
+
+func TestWithExactSize(t *testing.T) {
+	for _, size := range []int{1, 3, 100, 1000, 1025} {
+		f, err := NewFilter(size, WithExactSize())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if f.Size() != size {
+			t.Errorf("filter should have exactly %d slots, has: %d", size, f.Size())
+		}
+		for i := 0; i < 1000; i++ {
+			id := binary.BigEndian.AppendUint32(nil, uint32(i))
+			if index := f.caculateIndex(id); index >= uint64(size) {
+				t.Fatalf("index %d of %v is out of range for %d slots", index, id, size)
+			}
+			f.Contains(id)
+			if !f.Peek(id) {
+				t.Errorf("id %v should be contained", id)
+			}
+		}
+		g, _ := NewExactSizeFilter(size)
+		g.AbsorbFrom(f)
+		if !Equal(f, g) {
+			t.Errorf("filter should index like NewExactSizeFilter(%d)", size)
+		}
+	}
+	if _, err := NewFilter(100, WithExactSize(), WithMinSize(128)); err != ErrSizeBelowMin {
+		t.Errorf("exact size should be checked by WithMinSize, got: %v", err)
+	}
+}
+
+func TestWithMinSize(t *testing.T) {
+	for _, size := range []int{1, 2, 32} {
+		if f, err := NewFilter(size, WithMinSize(64)); err != ErrSizeBelowMin || f != nil {
+			t.Errorf("size %d should be rejected below the minimum, got: %v", size, err)
+		}
+		if _, err := NewFilter(size); err != nil {
+			t.Errorf("size %d should be accepted by default, got: %v", size, err)
+		}
+	}
+	// 33 rounds up to 64.
+	for _, size := range []int{33, 64, 1000} {
+		if _, err := NewFilter(size, WithMinSize(64)); err != nil {
+			t.Errorf("size %d should be accepted, got: %v", size, err)
+		}
+	}
+}
+
+func TestWithSkipRedundantStore(t *testing.T) {
+	f, _ := NewFilter(1, WithSkipRedundantStore())
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	f.Contains(first)
+	p := f.table.Load().slots[0].Load()
+	if !f.Contains(first) || f.table.Load().slots[0].Load() != p {
+		t.Errorf("repeated id should be contained without storing it again")
+	}
+	if f.Contains(second) || !f.Contains(second) || f.Peek(first) {
+		t.Errorf("colliding id should still evict")
+	}
+	want := Stats{Inserts: 4, Hits: 2, Evictions: 1}
+	if got := f.Stats(); got != want {
+		t.Errorf("stats should be %+v, got: %+v", want, got)
+	}
+}
+
+func benchmarkRepeatedId(b *testing.B, opts ...Option) {
+	f, _ := NewFilter(1<<16, opts...)
+	id := []byte{27, 28, 29}
+	f.Contains(id)
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f.Contains(id)
+		}
+	})
+}
+
+func BenchmarkRepeatedId(b *testing.B) {
+	benchmarkRepeatedId(b)
+}
+
+func BenchmarkRepeatedIdSkipRedundantStore(b *testing.B) {
+	benchmarkRepeatedId(b, WithSkipRedundantStore())
+}
+
+func TestWithByteOrder(t *testing.T) {
+	id := []byte{27, 28, 29}
+	digest := md5.Sum(id)
+	// The index an id should get when the digest's words are decoded in
+	// order, whatever the byte order of the host running the test.
+	want := func(order binary.ByteOrder, size int) uint64 {
+		hi := order.Uint32(digest[0:]) ^ order.Uint32(digest[4:])
+		lo := hi ^ order.Uint32(digest[8:]) ^ order.Uint32(digest[12:])
+		return (uint64(hi)<<32 | uint64(lo)) & uint64(size-1)
+	}
+	plain, _ := NewFilter(1 << 16)
+	little, _ := NewFilter(1<<16, WithByteOrder(binary.LittleEndian))
+	big, err := NewFilter(1<<16, WithByteOrder(binary.BigEndian))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := plain.caculateIndex(id); got != want(binary.LittleEndian, 1<<16) || got != little.caculateIndex(id) {
+		t.Errorf("default byte order should be little-endian, index: %d", got)
+	}
+	if got := big.caculateIndex(id); got != want(binary.BigEndian, 1<<16) {
+		t.Errorf("big-endian index should be %d, got: %d", want(binary.BigEndian, 1<<16), got)
+	}
+	shouldNotContain(t, "fresh id in a big-endian filter", big, id)
+	shouldContain(t, "seen id in a big-endian filter", big, id)
+
+	same := 0
+	for i := 0; i < 1000; i++ {
+		id := binary.BigEndian.AppendUint32(nil, uint32(i))
+		if little.caculateIndex(id) == big.caculateIndex(id) {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Errorf("%d of 1000 ids had the same index in both byte orders", same)
+	}
+	if f, err := NewFilter(4, WithByteOrder(nil)); err != ErrNilByteOrder || f != nil {
+		t.Errorf("did not error out on a nil byte order")
+	}
+}
+
+func TestContainsTimeout(t *testing.T) {
+	// The clock stands in for another goroutine: every time ContainsTimeout
+	// reads it between loading the slot and swapping id in, it changes the
+	// slot, so the swap fails, and a millisecond passes.
+	var f *Filter
+	contend := false
+	now := time.Unix(1000, 0)
+	clock := func() time.Time {
+		if contend {
+			f.table.Load().slots[0].Store(&[]byte{1})
+			now = now.Add(time.Millisecond)
+		}
+		return now
+	}
+	f, _ = NewFilter(1, WithClock(clock))
+	id := []byte{27, 28, 29}
+	if present, err := f.ContainsTimeout(id, time.Second); present || err != nil {
+		t.Errorf("uncontended ContainsTimeout should succeed, got: %v, %v", present, err)
+	}
+	if present, err := f.ContainsTimeout(id, time.Second); !present || err != nil {
+		t.Errorf("seen id should be contained, got: %v, %v", present, err)
+	}
+
+	contend = true
+	start := now
+	if present, err := f.ContainsTimeout([]byte{1, 2}, 5*time.Millisecond); present || err != context.DeadlineExceeded {
+		t.Errorf("contended ContainsTimeout should time out, got: %v, %v", present, err)
+	}
+	// The deadline is read a millisecond in, and the first read past it is
+	// at 7 milliseconds.
+	if waited := now.Sub(start); waited != 7*time.Millisecond {
+		t.Errorf("ContainsTimeout should give up once past the deadline, waited: %s", waited)
+	}
+	contend = false
+	if f.Peek([]byte{1, 2}) {
+		t.Errorf("timed out id should not be stored")
+	}
+	if present, err := f.ContainsTimeout(id, 0); present || err != nil {
+		t.Errorf("ContainsTimeout without a timeout should be Contains, got: %v, %v", present, err)
+	}
+}
+
+func TestWithMaxCASRetries(t *testing.T) {
+	f, err := NewFilter(1, WithMaxCASRetries(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if present, err := f.ContainsE([]byte{1}); present || err != nil {
+		t.Errorf("uncontended ContainsE should succeed, got: %v, %v", present, err)
+	}
+	if f, err := NewFilter(4, WithMaxCASRetries(0)); err != ErrInvalidRetries || f != nil {
+		t.Errorf("did not error out on a retry cap of 0")
+	}
+
+	// Another goroutine changing the slot between every load and
+	// compare-and-swap, as giveUp can between the two, makes them all fail.
+	f, _ = NewFilter(1, WithMaxCASRetries(3))
+	giveUp := f.casGiveUp()
+	calls := 0
+	contended := func(failed int) bool {
+		calls++
+		if giveUp(failed) {
+			return true
+		}
+		f.item(0).Store(&[]byte{byte(failed)})
+		return false
+	}
+	if present, err := f.containsE([]byte("a"), contended); present || err != ErrContention {
+		t.Errorf("ContainsE should give up with ErrContention, got: %v, %v", present, err)
+	}
+	if calls != 4 || f.Peek([]byte("a")) {
+		t.Errorf("ContainsE should give up after 3 retries without storing id, tried %d times", calls)
+	}
+	if f.MaxCASRetries() != 3 {
+		t.Errorf("the 3 retries should be recorded, got: %d", f.MaxCASRetries())
+	}
+}
+
+func TestWithAdaptiveHash(t *testing.T) {
+	f, err := NewFilter(1<<16, WithAdaptiveHash())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	plain, _ := NewFilter(1 << 16)
+	short, long := []byte{27, 28, 29}, []byte("an id long enough to be hashed")
+	if got, want := f.caculateIndex(short), f.shortSum(short)&f.mask(); got != want {
+		t.Errorf("short id should be mixed to index %d, got: %d", want, got)
+	}
+	if got, want := f.caculateIndex(long), plain.caculateIndex(long); got != want {
+		t.Errorf("long id should be hashed to index %d, got: %d", want, got)
+	}
+	if f.caculateIndex([]byte{1}) == f.caculateIndex([]byte{1, 0}) {
+		t.Errorf("short ids differing in trailing zeros should get different indexes")
+	}
+	for _, id := range [][]byte{{}, short, []byte("exactly8"), []byte("nine byte"), long} {
+		shouldNotContain(t, "fresh id with adaptive hashing", f, id)
+		shouldContain(t, "seen id with adaptive hashing", f, id)
+		if !f.ContainsString(string(id)) {
+			t.Errorf("string %q should be found in the slot of the same []byte id", id)
+		}
+	}
+
+	same := 0
+	for i := 0; i < 1000; i++ {
+		id := binary.BigEndian.AppendUint32(nil, uint32(i))
+		shouldNotContain(t, "fresh short id with adaptive hashing", f, id)
+		if f.caculateIndex(id) == plain.caculateIndex(id) {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Errorf("%d of 1000 short ids had the same index as with MD5", same)
+	}
+}
+
+func benchmarkMixedIds(b *testing.B, opts ...Option) {
+	f, _ := NewFilter(1<<16, opts...)
+	ids := make([][]byte, 1024)
+	for i := range ids {
+		ids[i] = binary.BigEndian.AppendUint32(nil, uint32(i))
+		if i%4 == 0 {
+			ids[i] = append(ids[i], "followed by a few dozen more bytes of id"...)
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.Contains(ids[i%len(ids)])
+	}
+}
+
+func BenchmarkMixedIds(b *testing.B) {
+	benchmarkMixedIds(b)
+}
+
+func BenchmarkMixedIdsAdaptiveHash(b *testing.B) {
+	benchmarkMixedIds(b, WithAdaptiveHash())
+}
+
+func TestWithInterning(t *testing.T) {
+	f, err := NewFilter(1024, WithInterning())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := []byte{27, 28, 29}
+	f.ContainsHashed(1, buf)
+	f.ContainsHashed(2, []byte{27, 28, 29})
+	first, second := *f.slot(1), *f.slot(2)
+	if &first[0] != &second[0] {
+		t.Errorf("the same id in two slots should share its backing array")
+	}
+	if &first[0] == &buf[0] {
+		t.Errorf("interned id should be a copy")
+	}
+	buf[0] = 1
+	if string(*f.slot(1)) != string([]byte{27, 28, 29}) {
+		t.Errorf("changing an id's buffer should not change the interned id")
+	}
+
+	g, _ := NewFilter(1, WithInterning())
+	g.Contains([]byte{1})
+	stored := *g.slot(0)
+	g.Contains([]byte{2})
+	g.Contains([]byte{1})
+	if again := *g.slot(0); &again[0] != &stored[0] {
+		t.Errorf("an id stored again after an eviction should share its backing array")
+	}
+}
+
+func TestEmptyIds(t *testing.T) {
+	f, _ := NewFilter(1024)
+	shouldNotContain(t, "fresh empty id", f, []byte{})
+	shouldContain(t, "seen empty id", f, []byte{})
+	shouldContain(t, "nil id, which is the empty id", f, nil)
+	if !f.ContainsString("") || f.Count() != 1 {
+		t.Errorf("empty id should be stored once, count: %d", f.Count())
+	}
+	f.Forget(nil)
+	if f.Peek([]byte{}) || f.Count() != 0 {
+		t.Errorf("forgotten empty id should not be mistaken for the forgotten slot")
+	}
+	shouldNotContain(t, "empty id after it was forgotten", f, []byte{})
+
+	r, err := NewFilter(1024, WithRejectEmpty())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if r.Contains(nil) || r.Contains([]byte{}) || r.ContainsString("") || r.ContainsPriority(nil, 1) {
+			t.Errorf("rejected empty id should never be contained")
+		}
+	}
+	if r.Peek(nil) || r.Count() != 0 || r.Inserts() != 0 {
+		t.Errorf("rejected empty id should not be stored or counted, count: %d", r.Count())
+	}
+	r.Forget(nil)
+	r.ForgetString("")
+	if _, err := r.ContainsE(nil); err != ErrEmptyID {
+		t.Errorf("ContainsE of an empty id should return ErrEmptyID, got: %v", err)
+	}
+	shouldNotContain(t, "fresh id with empty ids rejected", r, []byte{1})
+	shouldContain(t, "seen id with empty ids rejected", r, []byte{1})
+}
+
+func TestWithSampleRate(t *testing.T) {
+	id := func(i int) []byte { return binary.BigEndian.AppendUint32([]byte{'s'}, uint32(i)) }
+	never, _ := NewFilter(1024, WithSampleRate(0, 1))
+	for i := 0; i < 100; i++ {
+		if never.Contains(id(1)) {
+			t.Fatalf("filter sampling nothing should never contain an id")
+		}
+	}
+	if never.Count() != 0 {
+		t.Errorf("filter sampling nothing should stay empty, count: %d", never.Count())
+	}
+
+	always, _ := NewFilter(1024, WithSampleRate(1, 1))
+	plain, _ := NewFilter(1024)
+	for i := 0; i < 2000; i++ {
+		if always.Contains(id(i%700)) != plain.Contains(id(i%700)) {
+			t.Fatalf("filter sampling every id should behave like a plain one")
+		}
+	}
+
+	// An id is contained on its second occurrence if its first was sampled.
+	// The same seed picks the same ids, so the count is the same on every
+	// run.
+	half, _ := NewFilter(1<<20, WithSampleRate(0.25, 42))
+	again, _ := NewFilter(1<<20, WithSampleRate(0.25, 42))
+	const n = 10000
+	found := 0
+	for i := 0; i < n; i++ {
+		half.Contains(id(i))
+		again.Contains(id(i))
+		if half.Contains(id(i)) {
+			found++
+		}
+		again.Contains(id(i))
+		if half.Peek(id(i)) != again.Peek(id(i)) {
+			t.Fatalf("filters with the same seed should pick the same ids")
+		}
+	}
+	// The standard deviation is sqrt(n*0.25*0.75), about 43.
+	if found < n/4-220 || found > n/4+220 {
+		t.Errorf("about %d of %d ids should be sampled, got: %d", n/4, n, found)
+	}
+	other, _ := NewFilter(1<<20, WithSampleRate(0.25, 43))
+	same := 0
+	for i := 0; i < n; i++ {
+		other.Contains(id(i))
+		if other.Peek(id(i)) == half.Peek(id(i)) {
+			same++
+		}
+	}
+	// Independent picks agree on 0.25^2 + 0.75^2 of the ids.
+	if same > n*5/8+300 {
+		t.Errorf("filters with different seeds should pick different ids, agreed on %d of %d", same, n)
+	}
+
+	for _, rate := range []float64{-0.1, 1.5, math.NaN()} {
+		if _, err := NewFilter(16, WithSampleRate(rate, 1)); err != ErrInvalidSampleRate {
+			t.Errorf("rate %v should fail with ErrInvalidSampleRate, got: %v", rate, err)
+		}
+	}
+}
+
+func TestWithIndexBits(t *testing.T) {
+	id := func(i int) []byte { return binary.BigEndian.AppendUint32([]byte{'b'}, uint32(i)) }
+	if _, err := NewFilter(1<<17, WithIndexBits(16)); err != ErrIndexBitsTooFew {
+		t.Errorf("16 bits should be too few for 2^17 slots, got: %v", err)
+	}
+	small, _ := NewFilter(16, WithIndexBits(16))
+	if g, err := small.Resize(1 << 20); err != ErrIndexBitsTooFew || g != nil {
+		t.Errorf("Resize past 2^16 slots should fail with ErrIndexBitsTooFew, got: %v", err)
+	}
+	if g, err := small.Resize(1 << 16); err != nil || g.indexBits != 16 {
+		t.Errorf("Resize to 2^16 slots should keep the index bits, got: %v", err)
+	}
+	for _, n := range []int{0, 8, 33} {
+		if _, err := NewFilter(16, WithIndexBits(n)); err != ErrInvalidIndexBits {
+			t.Errorf("%d bits should fail with ErrInvalidIndexBits, got: %v", n, err)
+		}
+	}
+
+	for _, n := range []int{16, 32, 64} {
+		f, err := NewFilter(1<<16, WithIndexBits(n))
+		if err != nil {
+			t.Fatalf("%d bits should be enough for 2^16 slots, got: %v", n, err)
+		}
+		for i := 0; i < 1000; i++ {
+			if sum := f.caculateHash(id(i)); n < 64 && sum>>n != 0 {
+				t.Fatalf("%d-bit sum %#x has too many bits", n, sum)
+			}
+			if index := f.caculateIndex(id(i)); index >= uint64(f.Size()) {
+				t.Fatalf("index %d out of range for %d slots", index, f.Size())
+			}
+			f.Contains(id(i))
+			if !f.Peek(id(i)) {
+				t.Fatalf("%d-bit filter should hold the id it just added", n)
+			}
+		}
+	}
+
+	f, _ := NewFilter(1<<16, WithIndexBits(16))
+	if err := f.Grow(); err != ErrSizeTooLarge {
+		t.Errorf("16-bit filter should not grow past 2^16 slots, got: %v", err)
+	}
+}
+
+func TestBatchMethodsHonourOptions(t *testing.T) {
+	var ops []string
+	f, _ := NewFilter(1024, WithObserver(func(op string, _ time.Duration) { ops = append(ops, op) }))
+	f.ContainsAllParallel([][]byte{{1}, {2}}, 2)
+	f.ContainsOrLoad([]byte{3}, func([]byte) error { return nil })
+	if fmt.Sprint(ops) != "[contains_all_parallel contains_or_load]" {
+		t.Errorf("observer should see the batch methods, got: %v", ops)
+	}
+
+	// A rate of 0 picks no id, so nothing is inserted.
+	ids := [][]byte{{1}, {2}, {3}}
+	none, _ := NewFilterFromParallel(1024, ids, 2, WithSampleRate(0, 1))
+	none.ContainsAll(ids)
+	none.ContainsAllParallel(ids, 2)
+	none.ContainsOrLoad([]byte{4}, func([]byte) error { return nil })
+	if n := none.Count(); n != 0 {
+		t.Errorf("filter sampling no id should stay empty, holds: %d", n)
+	}
+}
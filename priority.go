@@ -0,0 +1,88 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// ContainsPriority is Contains, but records priority with id and only evicts
+// an id of the same or a lower priority. If id's slot holds a different id of
+// a higher priority, it is left there and ContainsPriority returns false
+// without inserting id. Adding the id already in its slot again keeps the
+// higher of its two priorities. Ids inserted by Contains and the other methods
+// have priority 0, so they never keep out an id inserted here.
+//
+// The priorities of a filter take 4 bytes per slot, allocated the first time
+// ContainsPriority is called. A priority is recorded just after its id is
+// stored, so under concurrent use an id may briefly be compared against the
+// priority of the id it replaced.
+func (f *Filter) ContainsPriority(id []byte, priority uint8) bool {
+	id = f.key(id)
+	if f.rejectEmpty && len(id) == 0 {
+		return false
+	}
+	t := f.table.Load()
+	index := t.reduce(f.caculateIndex(id))
+	item := &t.slots[index]
+	// stored is made once id is known to go in, so that WithInterning does
+	// not intern ids that are kept out.
+	var stored *[]byte
+	for {
+		old := item.Load()
+		ok := old != nil && old != forgeted
+		same := ok && bytes.Equal(*old, id)
+		if ok && !same && priority < t.priority(index) {
+			return false
+		}
+		if stored == nil {
+			s := f.storable(id)
+			stored = &s
+		}
+		if item.CompareAndSwap(old, stored) {
+			var oldId []byte
+			if ok {
+				oldId = *old
+			}
+			if same {
+				priority = max(priority, t.priority(index))
+			}
+			t.setPriority(index, priority)
+			present, _ := f.inserted(t, index, *stored, oldId, ok)
+			return present
+		}
+	}
+}
+
+// priority returns the priority recorded for the slot at index, or 0.
+func (t *table) priority(index uint64) uint8 {
+	if ps := t.priorities.Load(); ps != nil {
+		return uint8((*ps)[index].Load())
+	}
+	return 0
+}
+
+// setPriority records priority for the slot at index, allocating the
+// priorities only if priority is not 0.
+func (t *table) setPriority(index uint64, priority uint8) {
+	if ps := t.priorities.Load(); ps != nil {
+		(*ps)[index].Store(uint32(priority))
+	} else if priority != 0 {
+		t.priorityStore()[index].Store(uint32(priority))
+	}
+}
+
+// priorityStore returns the priorities of t, allocating them if needed.
+func (t *table) priorityStore() []atomic.Uint32 {
+	if ps := t.priorities.Load(); ps != nil {
+		return *ps
+	}
+	ps := make([]atomic.Uint32, len(t.slots))
+	if !t.priorities.CompareAndSwap(nil, &ps) {
+		return *t.priorities.Load()
+	}
+	return ps
+}
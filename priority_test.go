@@ -0,0 +1,78 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"testing"
+)
+
+func TestContainsPriority(t *testing.T) {
+	high := []byte{27, 28, 29}
+	low := []byte{27, 28, 30}
+	equal := []byte{27, 28, 31}
+
+	f, _ := NewFilter(1)
+	if f.ContainsPriority(high, 5) {
+		t.Errorf("fresh id should not be contained")
+	}
+	if f.ContainsPriority(low, 4) || !f.Peek(high) || f.Peek(low) {
+		t.Errorf("lower priority id should not evict a higher priority one")
+	}
+	if !f.ContainsPriority(high, 5) {
+		t.Errorf("higher priority id should still be contained")
+	}
+	if f.ContainsPriority(equal, 5) || !f.Peek(equal) {
+		t.Errorf("equal priority id should evict as normal")
+	}
+	if f.ContainsPriority(high, 6) || !f.Peek(high) {
+		t.Errorf("higher priority id should evict as normal")
+	}
+	if f.Stats().Evictions != 2 {
+		t.Errorf("kept out id should not count as an eviction, stats: %+v", f.Stats())
+	}
+
+	f.Contains(low)
+	if !f.Peek(low) {
+		t.Errorf("Contains should evict regardless of priority")
+	}
+	if f.ContainsPriority(high, 0) || !f.Peek(high) {
+		t.Errorf("id inserted by Contains should have priority 0")
+	}
+	f.ContainsPriority(high, 9)
+	g := f.Clone()
+	if err := f.Grow(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, h := range []*Filter{f, g} {
+		index := h.caculateIndex(high)
+		if p := h.table.Load().priority(index); p != 9 {
+			t.Errorf("priority should be kept by Clone and Grow, got: %d", p)
+		}
+	}
+}
+
+func TestContainsPriorityInterning(t *testing.T) {
+	f, _ := NewFilter(1, WithInterning())
+	f.ContainsPriority([]byte("high"), 5)
+	if f.ContainsPriority([]byte("low"), 1) {
+		t.Fatalf("lower priority id should be kept out")
+	}
+	if _, ok := f.interned.Load("low"); ok {
+		t.Errorf("id kept out should not be interned")
+	}
+	if _, ok := f.interned.Load("high"); !ok {
+		t.Errorf("stored id should be interned")
+	}
+}
+
+func TestContainsPriorityKeepsHigher(t *testing.T) {
+	f, _ := NewFilter(1)
+	f.ContainsPriority([]byte("a"), 5)
+	if !f.ContainsPriority([]byte("a"), 1) {
+		t.Fatalf("id added again should be contained")
+	}
+	if f.ContainsPriority([]byte("b"), 3) || !f.Peek([]byte("a")) {
+		t.Errorf("adding an id again at a lower priority should keep its higher one")
+	}
+}
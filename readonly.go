@@ -0,0 +1,28 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+// ReadOnlyFilter queries a filter without adding or forgetting ids.
+type ReadOnlyFilter interface {
+	Peek(id []byte) bool
+	Size() int
+	Count() int
+}
+
+// readOnly hides the filter's other methods, so a ReadOnlyFilter cannot be
+// asserted back to a *Filter.
+type readOnly struct {
+	f *Filter
+}
+
+// ReadOnly returns a view of f that can only be queried. It shares f's slots,
+// so it sees every later change made through f.
+func (f *Filter) ReadOnly() ReadOnlyFilter {
+	return readOnly{f}
+}
+
+func (r readOnly) Peek(id []byte) bool { return r.f.Peek(id) }
+func (r readOnly) Size() int           { return r.f.Size() }
+func (r readOnly) Count() int          { return r.f.Count() }
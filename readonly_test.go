@@ -0,0 +1,28 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	f, _ := NewFilter(1024)
+	id := []byte{1, 2, 3}
+	r := f.ReadOnly()
+	if r.Peek(id) || r.Count() != 0 || r.Size() != 1024 {
+		t.Errorf("view of an empty filter should be empty")
+	}
+	f.Contains(id)
+	if !r.Peek(id) || r.Count() != 1 {
+		t.Errorf("view should see ids added through the filter")
+	}
+	f.Forget(id)
+	if r.Peek(id) || r.Count() != 0 {
+		t.Errorf("view should see ids forgotten through the filter")
+	}
+	if _, ok := r.(*Filter); ok {
+		t.Errorf("view should not be a *Filter")
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/fnv"
+)
+
+var ErrSizeMismatch = errors.New("oppobloom: filters have different sizes")
+
+// Compatible returns nil if a and b have the same size and index ids alike,
+// as Union, Intersect, Equal and Similarity need, so that callers can check
+// before combining them. Otherwise it returns an error wrapping
+// ErrHashMismatch that says how their hashes differ, or one wrapping
+// ErrSizeMismatch, which ReplaceWith and AbsorbFrom accept unlike the others.
+func Compatible(a, b *Filter) error {
+	switch {
+	case a.fingerprint != b.fingerprint:
+		return fmt.Errorf("%w: only one is a fingerprint filter", ErrHashMismatch)
+	case a.exact != b.exact:
+		return fmt.Errorf("%w: only one is an exact size filter", ErrHashMismatch)
+	case !bytes.Equal(a.seed, b.seed):
+		return fmt.Errorf("%w: seeds %d and %d", ErrHashMismatch, a.Config().Seed, b.Config().Seed)
+	case !a.indexesLike(b) && a.hashName == b.hashName:
+		return fmt.Errorf("%w: %s hashes configured differently", ErrHashMismatch, a.hashName)
+	case !a.indexesLike(b):
+		return fmt.Errorf("%w: hashes %s and %s", ErrHashMismatch, a.hashName, b.hashName)
+	case a.Size() != b.Size():
+		return fmt.Errorf("%w: %d and %d slots", ErrSizeMismatch, a.Size(), b.Size())
+	}
+	return nil
+}
+
+// Union returns a filter configured like a that holds, in each slot, the id
+// from that slot of a or, if a's slot is empty, of b. a and b must have the
+// same size and hash, so that each id has the same slot in both. As every
+// slot holds a single id, ids in b that collide with a different id in a are
+// not in the union.
+func Union(a, b *Filter) (*Filter, error) {
+	return combine(a, b, func(x, y *[]byte) *[]byte {
+		if x != nil {
+			return x
+		}
+		return y
+	})
+}
+
+// Intersect returns a filter configured like a that holds the ids that are in
+// the same slot of both a and b. a and b must have the same size and hash.
+// Ids evicted from either filter by collisions are not in the intersection,
+// even if both filters saw them.
+func Intersect(a, b *Filter) (*Filter, error) {
+	return combine(a, b, func(x, y *[]byte) *[]byte {
+		if x != nil && y != nil && bytes.Equal(*x, *y) {
+			return x
+		}
+		return nil
+	})
+}
+
+// Equal returns true if a and b have the same size and every slot of a holds
+// the same id as that slot of b, or both are empty. Forgotten slots are empty.
+// Slots are compared one at a time, so Equal is only meaningful while neither
+// filter is being changed.
+func Equal(a, b *Filter) bool {
+	at, bt := a.table.Load(), b.table.Load()
+	if len(at.slots) != len(bt.slots) {
+		return false
+	}
+	for i := range at.slots {
+		x, y := at.slot(i), bt.slot(i)
+		if (x == nil) != (y == nil) || x != nil && !bytes.Equal(*x, *y) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fingerprint returns a 64-bit FNV-1a hash of the filter's size and of the
+// index and id of each slot holding one, in slot order, so that filters that
+// are Equal have the same fingerprint and ones that differ almost surely
+// don't, e.g. to compare replicas without shipping snapshots of them. Like
+// Snapshot it reads the slots one at a time, so under concurrent use it hashes
+// no single state of the filter.
+func (f *Filter) Fingerprint() uint64 {
+	t := f.table.Load()
+	h := fnv.New64a()
+	buf := binary.AppendUvarint(nil, uint64(len(t.slots)))
+	for i := range t.slots {
+		if p := t.slot(i); p != nil {
+			buf = binary.AppendUvarint(buf, uint64(i))
+			buf = binary.AppendUvarint(buf, uint64(len(*p)))
+			buf = append(buf, *p...)
+		}
+		if len(buf) >= 4096 {
+			h.Write(buf)
+			buf = buf[:0]
+		}
+	}
+	h.Write(buf)
+	return h.Sum64()
+}
+
+// Similarity estimates the Jaccard similarity of the ids held by a and b: the
+// number of slots in which both hold the same id over the number of slots in
+// which either holds one. It is 1 for two empty filters and 0 if a and b have
+// different sizes. As each slot holds a single id, an id that one filter lost
+// to a collision counts as not shared, so Similarity understates the overlap
+// of the ids the filters saw when they are full. Like Equal, it is only
+// meaningful while neither filter is being changed.
+func Similarity(a, b *Filter) float64 {
+	at, bt := a.table.Load(), b.table.Load()
+	if len(at.slots) != len(bt.slots) {
+		return 0
+	}
+	var both, either int
+	for i := range at.slots {
+		x, y := at.slot(i), bt.slot(i)
+		if x == nil && y == nil {
+			continue
+		}
+		either++
+		if x != nil && y != nil && bytes.Equal(*x, *y) {
+			both++
+		}
+	}
+	if either == 0 {
+		return 1
+	}
+	return float64(both) / float64(either)
+}
+
+// Rehash adds every id held by src to dst, hashed with dst's hash, to migrate
+// ids between filters of different sizes or hashes. It is dst.AbsorbFrom(src).
+func Rehash(src, dst *Filter) {
+	dst.AbsorbFrom(src)
+}
+
+// AbsorbFrom adds every id held by other to f, as Add would, so that filters,
+// e.g. of shards, can be consolidated into one. Unlike Union, f and other may
+// have different sizes: each id goes to its slot in f, evicting what was there.
+// Each id is hashed again with f's hash, so AbsorbFrom also migrates ids to a
+// filter with a different hash or seed, e.g. from MD5 to SipHash, while other
+// is still in use. The two should otherwise be configured alike, as an id
+// fingerprinted by other would be stored in f as is. Slots of other are read
+// one at a time, as by Range.
+func (f *Filter) AbsorbFrom(other *Filter) {
+	h := f.hashes.Get().(hash.Hash32)
+	defer f.hashes.Put(h)
+	uint64s := other.uint64s.Load()
+	if uint64s {
+		f.uint64s.Store(true)
+	}
+	other.Range(func(id []byte) bool {
+		if uint64s && len(id) == 8 {
+			f.insert(f.reduce(f.uint64Sum(binary.BigEndian.Uint64(id))), id)
+		} else {
+			f.insert(f.caculateIndexWith(h, id), id)
+		}
+		return true
+	})
+}
+
+// ContainsAny calls Contains on each of filters in order and returns true if
+// any of them already held id. Every filter is called, so id is in all of them
+// afterwards, as when one dedups against several windows at once.
+func ContainsAny(id []byte, filters ...*Filter) bool {
+	present := false
+	for _, f := range filters {
+		if f.Contains(id) {
+			present = true
+		}
+	}
+	return present
+}
+
+// PeekAny returns true if any of filters holds id, in order, without adding it
+// to any of them.
+func PeekAny(id []byte, filters ...*Filter) bool {
+	for _, f := range filters {
+		if f.Peek(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// combine returns a filter configured like a with each slot set to pick of
+// the ids in that slot of a and b, which are nil for empty slots.
+func combine(a, b *Filter, pick func(x, y *[]byte) *[]byte) (*Filter, error) {
+	if a.Size() != b.Size() {
+		return nil, ErrSizeMismatch
+	}
+	g, err := a.emptyCopy(a.Size())
+	if err != nil {
+		return nil, err
+	}
+	at, bt, gt := a.table.Load(), b.table.Load(), g.table.Load()
+	for i := range gt.slots {
+		if p := pick(at.slot(i), bt.slot(i)); p != nil {
+			gt.slots[i].Store(p)
+			g.occupied.Add(1)
+		}
+	}
+	return g, nil
+}
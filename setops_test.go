@@ -0,0 +1,307 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"testing"
+)
+
+func TestUnion(t *testing.T) {
+	a, _ := NewFilter(1 << 16)
+	b, _ := NewFilter(1 << 16)
+	a.Contains([]byte("only in a"))
+	b.Contains([]byte("only in b"))
+	a.Contains([]byte("in both"))
+	b.Contains([]byte("in both"))
+	u, err := Union(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, id := range []string{"only in a", "only in b", "in both"} {
+		if !u.Peek([]byte(id)) {
+			t.Errorf("union should hold %q", id)
+		}
+	}
+	if u.Count() != 3 {
+		t.Errorf("union should hold 3 ids, holds: %d", u.Count())
+	}
+	if a.Count() != 2 || b.Count() != 2 {
+		t.Errorf("union should not change its inputs")
+	}
+}
+
+func TestUnionPrefersFirst(t *testing.T) {
+	a, _ := NewFilter(1)
+	b, _ := NewFilter(1)
+	a.Contains([]byte{1})
+	b.Contains([]byte{2})
+	u, _ := Union(a, b)
+	if !u.Peek([]byte{1}) {
+		t.Errorf("union should keep a's id when both slots are full")
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	a, _ := NewFilter(1 << 16)
+	b, _ := NewFilter(1 << 16)
+	a.Contains([]byte("only in a"))
+	b.Contains([]byte("only in b"))
+	a.Contains([]byte("in both"))
+	b.Contains([]byte("in both"))
+	i, err := Intersect(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !i.Peek([]byte("in both")) {
+		t.Errorf("intersection should hold the shared id")
+	}
+	if i.Count() != 1 {
+		t.Errorf("intersection should hold 1 id, holds: %d", i.Count())
+	}
+}
+
+func TestCompatible(t *testing.T) {
+	build := builder(t)
+	a := build(NewFilter(1024))
+	for _, c := range []struct {
+		b    *Filter
+		want error
+		msg  string
+	}{
+		{build(NewFilter(1000)), nil, ""},
+		{build(NewFilterWithSeed(1024, 0)), nil, ""},
+		{build(NewFilter(1024, WithByteOrder(binary.LittleEndian))), nil, ""},
+		{build(NewFilter(2048)), ErrSizeMismatch, "oppobloom: filters have different sizes: 1024 and 2048 slots"},
+		{build(NewFilterWithSeed(1024, 7)), ErrHashMismatch, "oppobloom: filters index ids differently: seeds 0 and 7"},
+		{build(NewFilterWithHash(1024, fnv.New32a)), ErrHashMismatch, "oppobloom: filters index ids differently: hashes md5 and fnv32a"},
+		{build(NewFilter(1024, WithByteOrder(binary.BigEndian))), ErrHashMismatch, "oppobloom: filters index ids differently: md5 hashes configured differently"},
+		{build(NewFingerprintFilter(1024)), ErrHashMismatch, "oppobloom: filters index ids differently: only one is a fingerprint filter"},
+		{build(NewExactSizeFilter(1024)), ErrHashMismatch, "oppobloom: filters index ids differently: only one is an exact size filter"},
+	} {
+		err := Compatible(a, c.b)
+		if c.want == nil && err != nil || c.want != nil && (!errors.Is(err, c.want) || err.Error() != c.msg) {
+			t.Errorf("Compatible of %v should return %q, got: %v", c.b.Config(), c.msg, err)
+		}
+	}
+}
+
+func TestSetOpsSizeMismatch(t *testing.T) {
+	a, _ := NewFilter(4)
+	b, _ := NewFilter(8)
+	if u, err := Union(a, b); err != ErrSizeMismatch || u != nil {
+		t.Errorf("union of different sizes should fail, got: %v", err)
+	}
+	if i, err := Intersect(a, b); err != ErrSizeMismatch || i != nil {
+		t.Errorf("intersection of different sizes should fail, got: %v", err)
+	}
+}
+
+func TestSimilarity(t *testing.T) {
+	a, _ := NewFilter(1)
+	b, _ := NewFilter(1)
+	if s := Similarity(a, b); s != 1 {
+		t.Errorf("empty filters should have similarity 1, got: %v", s)
+	}
+
+	a, _ = NewFilter(4096)
+	b, _ = NewFilter(4096)
+	for i := 0; i < 30; i++ {
+		a.Contains([]byte{byte(i), 2})
+		b.Contains([]byte{byte(i), 2})
+	}
+	for i := 0; i < 10; i++ {
+		a.Contains([]byte{byte(i), 3})
+		b.Contains([]byte{byte(i), 4})
+	}
+	shared, occupied := 0, 0
+	for i := 0; i < a.Size(); i++ {
+		x, y := a.slot(uint64(i)), b.slot(uint64(i))
+		if x != nil || y != nil {
+			occupied++
+		}
+		if x != nil && y != nil && string(*x) == string(*y) {
+			shared++
+		}
+	}
+	if shared < 20 || occupied < 40 {
+		t.Fatalf("too many collisions to test: %d shared of %d occupied", shared, occupied)
+	}
+	want := float64(shared) / float64(occupied)
+	if s := Similarity(a, b); s != want || Similarity(b, a) != want {
+		t.Errorf("overlapping filters should have similarity %v, got: %v", want, s)
+	}
+	if s := Similarity(a, a); s != 1 {
+		t.Errorf("filter should have similarity 1 with itself, got: %v", s)
+	}
+
+	c, _ := NewFilter(4096)
+	for i := 0; i < 30; i++ {
+		c.Contains([]byte{byte(i), 5})
+	}
+	if s := Similarity(a, c); s != 0 {
+		t.Errorf("disjoint filters should have similarity 0, got: %v", s)
+	}
+	d, _ := NewFilter(2048)
+	if s := Similarity(a, d); s != 0 {
+		t.Errorf("filters with different sizes should have similarity 0, got: %v", s)
+	}
+}
+
+func TestAbsorbFrom(t *testing.T) {
+	index := func(id []byte) int { return int(id[0]) }
+	f, _ := NewFilterForTest(256, index)
+	a, _ := NewFilterForTest(64, index)
+	b, _ := NewFilterForTest(128, index)
+	for i := 0; i < 100; i++ {
+		if i < 50 {
+			a.Contains([]byte{byte(i), 'a'})
+		} else {
+			b.Contains([]byte{byte(i), 'b'})
+		}
+	}
+	f.Contains([]byte{200})
+	f.AbsorbFrom(a)
+	f.AbsorbFrom(b)
+	for i := 0; i < 100; i++ {
+		id := []byte{byte(i), 'a'}
+		if i >= 50 {
+			id[1] = 'b'
+		}
+		if !f.Peek(id) {
+			t.Errorf("absorbed id %v should be in the receiver", id)
+		}
+	}
+	shouldContain(t, "receiver's own id after absorbing", f, []byte{200})
+	if f.Count() != 101 || a.Count() != 50 || b.Count() != 50 {
+		t.Errorf("absorbing should only add to the receiver, counts: %d, %d, %d", f.Count(), a.Count(), b.Count())
+	}
+
+	g, _ := NewFilter(1 << 16)
+	small, _ := NewFilter(1 << 10)
+	for i := 0; i < 100; i++ {
+		small.Contains([]byte{byte(i), 1, 2})
+	}
+	g.AbsorbFrom(small)
+	missing := 0
+	small.Range(func(id []byte) bool {
+		if !g.Peek(id) {
+			missing++
+		}
+		return true
+	})
+	// About 100*100/2^17 ids are expected to collide in g.
+	if missing > 2 {
+		t.Errorf("%d ids absorbed from a smaller filter are missing", missing)
+	}
+}
+
+func TestRehash(t *testing.T) {
+	src, _ := NewFilter(1 << 12)
+	dst, _ := NewFilterSipHash(1<<12, 1, 2)
+	ids := make([][]byte, 200)
+	for i := range ids {
+		ids[i] = []byte{byte(i), 'm'}
+		src.Contains(ids[i])
+	}
+	Rehash(src, dst)
+	if dst.Count() < src.Count()-5 {
+		t.Errorf("ids should survive the migration but for a few collisions, kept %d of %d", dst.Count(), src.Count())
+	}
+	for _, id := range ids {
+		// An id can only be missing from dst if it lost its slot there to
+		// another id.
+		if src.Peek(id) && !dst.Peek(id) && dst.slot(dst.caculateIndex(id)) == nil {
+			t.Errorf("migrated id %v should be found with the new hash", id)
+		}
+	}
+}
+
+func TestContainsAny(t *testing.T) {
+	recent, _ := NewFilter(1024)
+	old, _ := NewFilter(1024)
+	id := []byte{27, 28, 29}
+	old.Contains(id)
+	if !PeekAny(id, recent, old) || recent.Peek(id) {
+		t.Errorf("PeekAny should find the id in the second filter without adding it")
+	}
+	if PeekAny([]byte{1}, recent, old) || PeekAny(id) {
+		t.Errorf("PeekAny should not find a fresh id")
+	}
+	if !ContainsAny(id, recent, old) {
+		t.Errorf("ContainsAny should find the id in the second filter")
+	}
+	if !recent.Peek(id) {
+		t.Errorf("ContainsAny should add the id to every filter")
+	}
+	fresh := []byte{1, 2, 3}
+	if ContainsAny(fresh, recent, old) || !recent.Peek(fresh) || !old.Peek(fresh) {
+		t.Errorf("ContainsAny should report a fresh id as new and add it to every filter")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a, _ := NewFilter(1024)
+	b, _ := NewFilter(1024)
+	if !Equal(a, b) {
+		t.Errorf("empty filters should be equal")
+	}
+	for i := 0; i < 50; i++ {
+		a.Contains([]byte{byte(i), 1})
+		b.Contains([]byte{byte(i), 1})
+	}
+	a.Contains([]byte{0xff})
+	a.Forget([]byte{0xff})
+	if !Equal(a, b) || !Equal(b, a) {
+		t.Errorf("filters with the same ids should be equal, even with forgotten slots")
+	}
+	data, _ := a.MarshalBinary()
+	var c Filter
+	c.UnmarshalBinary(data)
+	if !Equal(a, &c) {
+		t.Errorf("filter should equal its round-tripped snapshot")
+	}
+
+	b.Contains([]byte{0xfe})
+	if Equal(a, b) || Equal(b, a) {
+		t.Errorf("filters with different ids should not be equal")
+	}
+	d, _ := NewFilter(2048)
+	e, _ := NewFilter(1024)
+	if Equal(d, e) {
+		t.Errorf("filters with different sizes should not be equal")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a, _ := NewFilter(1024)
+	b, _ := NewFilter(1024)
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Errorf("empty filters should have the same fingerprint")
+	}
+	small, _ := NewFilter(512)
+	if a.Fingerprint() == small.Fingerprint() {
+		t.Errorf("empty filters of different sizes should have different fingerprints")
+	}
+	for i := 0; i < 50; i++ {
+		a.Contains([]byte{byte(i), 1})
+		b.Contains([]byte{byte(i), 1})
+	}
+	// Forgotten slots are empty, like in Equal.
+	a.Contains([]byte{0xff})
+	a.Forget([]byte{0xff})
+	if a.Fingerprint() != b.Fingerprint() {
+		t.Errorf("equal filters should have the same fingerprint")
+	}
+	before := a.Fingerprint()
+	a.Contains([]byte{0xfe})
+	if a.Fingerprint() == before || a.Fingerprint() == b.Fingerprint() {
+		t.Errorf("an extra id should change the fingerprint")
+	}
+	if a.Fingerprint() != a.Clone().Fingerprint() {
+		t.Errorf("clone should have the same fingerprint")
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"errors"
+	"hash"
+)
+
+var ErrInvalidShards = errors.New("oppobloom: number of shards must be positive")
+
+// A ShardedFilter is a Filter split into shards with separate arrays, which
+// spreads the goroutines operating on it over more memory. It behaves like a
+// Filter of the same total size.
+type ShardedFilter struct {
+	shards []*Filter
+}
+
+// NewShardedFilter returns a ShardedFilter of shards shards with at least
+// size slots between them. Each shard's size is rounded up to a power of two.
+// Ids are placed in a shard by their hash, so a shard cannot grow on its own
+// and NewShardedFilter returns ErrGrowUnsupported if opts include
+// WithAutoGrow. The shards share one audit log if opts include WithAuditLog.
+func NewShardedFilter(size, shards int, opts ...Option) (*ShardedFilter, error) {
+	if shards <= 0 {
+		return nil, ErrInvalidShards
+	}
+	if size <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	s := &ShardedFilter{make([]*Filter, shards)}
+	for i := range s.shards {
+		f, err := NewFilter((size+shards-1)/shards, opts...)
+		if err != nil {
+			return nil, err
+		}
+		if f.autoGrow > 0 {
+			return nil, ErrGrowUnsupported
+		}
+		if i > 0 {
+			// Each shard made its own, with its own lock around the same
+			// writer.
+			f.auditLog = s.shards[0].auditLog
+		}
+		s.shards[i] = f
+	}
+	return s, nil
+}
+
+// ShardIndex returns which of numShards shards id belongs to, from the MD5
+// hash filters made by NewFilter use, for callers routing ids to filters of
+// their own. Any positive numShards works, as the hash is reduced modulo it,
+// and ShardIndex panics for others. The shard comes from the high 32 bits of
+// the hash while such filters index with the low bits, so the ids of a shard
+// still spread over every slot of a filter of up to 2^32 slots.
+func ShardIndex(id []byte, numShards int) int {
+	if numShards <= 0 {
+		panic(ErrInvalidShards)
+	}
+	h := shardHashes.Get().(hash.Hash32)
+	defer shardHashes.Put(h)
+	h.Reset()
+	h.Write(id)
+	return int(sum64(h) >> 32 % uint64(numShards))
+}
+
+var shardHashes = newHashPool(newMD5UintHash)
+
+// Contains adds id to the filter and then returns true if id already existed.
+func (s *ShardedFilter) Contains(id []byte) bool {
+	f, index, id := s.locate(id)
+	return f.containsAt(index, id)
+}
+
+// Forget removes id if it is in the filter.
+func (s *ShardedFilter) Forget(id []byte) {
+	f, index, id := s.locate(id)
+	f.forgetAt(index, id)
+}
+
+// Peek returns true if id is in the filter without adding it.
+func (s *ShardedFilter) Peek(id []byte) bool {
+	f, index, id := s.locate(id)
+	return f.peekAt(index, id)
+}
+
+// Size returns the total number of slots in the shards.
+func (s *ShardedFilter) Size() int {
+	n := 0
+	for _, f := range s.shards {
+		n += f.Size()
+	}
+	return n
+}
+
+// Count returns the total number of slots holding an id in the shards.
+func (s *ShardedFilter) Count() int {
+	n := 0
+	for _, f := range s.shards {
+		n += f.Count()
+	}
+	return n
+}
+
+// locate returns id's shard, its index in that shard and the key the shard
+// stores for it. A single hash picks both the shard and the index.
+func (s *ShardedFilter) locate(id []byte) (*Filter, uint64, []byte) {
+	f := s.shards[0]
+	id = f.key(id)
+	h := f.hashes.Get().(hash.Hash32)
+	sum := f.caculateSum(h, id)
+	f.hashes.Put(h)
+	n := uint64(len(s.shards))
+	f = s.shards[sum%n]
+	return f, f.reduce(sum / n), id
+}
@@ -0,0 +1,148 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestShardedFilter(t *testing.T) {
+	s, err := NewShardedFilter(1<<16, 8)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if s.Size() != 1<<16 {
+		t.Errorf("sharded filter should have %d slots, has: %d", 1<<16, s.Size())
+	}
+	var id []byte
+	for i := 0; i < 1000; i++ {
+		id = binary.BigEndian.AppendUint32(nil, uint32(i))
+		if s.Contains(id) {
+			t.Errorf("fresh id %v should not be contained", id)
+		}
+		if !s.Contains(id) || !s.Peek(id) {
+			t.Errorf("seen id %v should be contained", id)
+		}
+	}
+	for _, f := range s.shards {
+		if f.Count() == 0 {
+			t.Errorf("every shard should hold some ids")
+		}
+	}
+	s.Forget(id)
+	if s.Peek(id) {
+		t.Errorf("forgotten id should not be contained")
+	}
+	if c := s.Count(); c > 999 || c < 975 {
+		t.Errorf("count should be close to 999, got: %d", c)
+	}
+}
+
+func TestInvalidShards(t *testing.T) {
+	if s, err := NewShardedFilter(16, 0); err != ErrInvalidShards || s != nil {
+		t.Errorf("did not error out on zero shards")
+	}
+	if s, err := NewShardedFilter(0, 4); err != ErrSizeTooSmall || s != nil {
+		t.Errorf("did not error out on a zero size")
+	}
+}
+
+func TestShardedFilterAutoGrow(t *testing.T) {
+	s, err := NewShardedFilter(64, 4, WithAutoGrow(0.5))
+	if err != ErrGrowUnsupported || s != nil {
+		t.Errorf("did not error out on WithAutoGrow, got: %v", err)
+	}
+}
+
+func TestShardedFilterAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	s, _ := NewShardedFilter(1<<10, 4, WithAuditLog(&buf))
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				s.Contains([]byte{byte(g), byte(i)})
+			}
+		}(g)
+	}
+	wg.Wait()
+	n := 0
+	for data := buf.Bytes(); len(data) > 0; n++ {
+		length, k := binary.Uvarint(data)
+		data = data[k+int(length):]
+	}
+	if n != 200 {
+		t.Errorf("audit log should hold the 200 ids, holds: %d", n)
+	}
+}
+
+func TestShardIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	counts := make([]int, 7)
+	id := make([]byte, 16)
+	for i := 0; i < 70000; i++ {
+		r.Read(id)
+		n := ShardIndex(id, len(counts))
+		if ShardIndex(id, len(counts)) != n {
+			t.Fatalf("shard of %v should be stable", id)
+		}
+		counts[n]++
+	}
+	for n, c := range counts {
+		if c < 9500 || c > 10500 {
+			t.Errorf("shard %d should get about 10000 ids, got: %d", n, c)
+		}
+	}
+
+	// The ids of one shard should use all the slots of its filter.
+	f, _ := NewFilter(256)
+	for i := 0; f.Count() < 200 && i < 100000; i++ {
+		id = binary.BigEndian.AppendUint32(id[:0], uint32(i))
+		if ShardIndex(id, 4) == 0 {
+			f.Contains(id)
+		}
+	}
+	if c := f.Count(); c < 200 {
+		t.Errorf("ids of one shard should fill its filter, filled %d of %d slots", c, f.Size())
+	}
+
+	defer func() {
+		if recover() != ErrInvalidShards {
+			t.Errorf("zero shards should panic with ErrInvalidShards")
+		}
+	}()
+	ShardIndex(id, 0)
+}
+
+// benchmarkOverlapping runs contains with 64 goroutines probing ids from a
+// small, shared set. RunParallel starts GOMAXPROCS goroutines per unit of
+// parallelism, so that is how many units 64 takes.
+func benchmarkOverlapping(b *testing.B, contains func([]byte) bool) {
+	var next uint32
+	b.SetParallelism(max(1, 64/runtime.GOMAXPROCS(0)))
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			contains(binary.BigEndian.AppendUint32(nil, atomic.AddUint32(&next, 1)%256))
+		}
+	})
+}
+
+func BenchmarkPlainOverlapping(b *testing.B) {
+	f, _ := NewFilter(1 << 16)
+	benchmarkOverlapping(b, f.Contains)
+}
+
+func BenchmarkShardedOverlapping(b *testing.B) {
+	s, _ := NewShardedFilter(1<<16, 16)
+	benchmarkOverlapping(b, s.Contains)
+}
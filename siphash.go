@@ -0,0 +1,111 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// NewFilterSipHash returns a filter of at least size slots that indexes ids
+// with SipHash-2-4 keyed by k0 and k1. SipHash is not a cryptographic digest,
+// but it is a secure pseudorandom function: without the key, ids cannot be
+// crafted to collide, which makes it a fast defense against collision
+// flooding. Keep the key secret and pick it at random, e.g. from crypto/rand.
+func NewFilterSipHash(size int, k0, k1 uint64, opts ...Option) (*Filter, error) {
+	f, err := NewFilterWithHash64(size, func() hash.Hash64 { return newSipHash(k0, k1) }, opts...)
+	if err != nil {
+		return nil, err
+	}
+	f.hashName = "siphash"
+	return f, nil
+}
+
+// sipHash is a streaming SipHash-2-4.
+type sipHash struct {
+	k0, k1         uint64
+	v0, v1, v2, v3 uint64
+	buf            [8]byte
+	nbuf           int
+	length         uint64
+}
+
+func newSipHash(k0, k1 uint64) *sipHash {
+	h := &sipHash{k0: k0, k1: k1}
+	h.Reset()
+	return h
+}
+
+func (h *sipHash) Reset() {
+	h.v0 = h.k0 ^ 0x736f6d6570736575
+	h.v1 = h.k1 ^ 0x646f72616e646f6d
+	h.v2 = h.k0 ^ 0x6c7967656e657261
+	h.v3 = h.k1 ^ 0x7465646279746573
+	h.nbuf = 0
+	h.length = 0
+}
+
+func (h *sipHash) Size() int      { return 8 }
+func (h *sipHash) BlockSize() int { return 8 }
+
+func (h *sipHash) Write(p []byte) (int, error) {
+	n := len(p)
+	h.length += uint64(n)
+	if h.nbuf > 0 {
+		c := copy(h.buf[h.nbuf:], p)
+		h.nbuf += c
+		p = p[c:]
+		if h.nbuf < 8 {
+			return n, nil
+		}
+		h.block(binary.LittleEndian.Uint64(h.buf[:]))
+		h.nbuf = 0
+	}
+	for ; len(p) >= 8; p = p[8:] {
+		h.block(binary.LittleEndian.Uint64(p))
+	}
+	h.nbuf = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *sipHash) block(m uint64) {
+	h.v3 ^= m
+	h.rounds(2)
+	h.v0 ^= m
+}
+
+func (h *sipHash) rounds(n int) {
+	v0, v1, v2, v3 := h.v0, h.v1, h.v2, h.v3
+	for i := 0; i < n; i++ {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13) ^ v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16) ^ v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21) ^ v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17) ^ v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	h.v0, h.v1, h.v2, h.v3 = v0, v1, v2, v3
+}
+
+// Sum64 finishes a copy of the state, so that more may be written after.
+func (h *sipHash) Sum64() uint64 {
+	d := *h
+	var last [8]byte
+	copy(last[:], d.buf[:d.nbuf])
+	last[7] = byte(d.length)
+	d.block(binary.LittleEndian.Uint64(last[:]))
+	d.v2 ^= 0xff
+	d.rounds(4)
+	return d.v0 ^ d.v1 ^ d.v2 ^ d.v3
+}
+
+func (h *sipHash) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, h.Sum64())
+}
@@ -0,0 +1,61 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSipHashVectors(t *testing.T) {
+	// From the SipHash paper, with the key 00 01 ... 0f.
+	k0, k1 := uint64(0x0706050403020100), uint64(0x0f0e0d0c0b0a0908)
+	msg := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14}
+	for _, tc := range []struct {
+		msg  []byte
+		want uint64
+	}{
+		{nil, 0x726fdb47dd0e0e31},
+		{msg, 0xa129ca6149be45e5},
+	} {
+		h := newSipHash(k0, k1)
+		h.Write(tc.msg)
+		if got := h.Sum64(); got != tc.want {
+			t.Errorf("SipHash of %v should be %#x, got: %#x", tc.msg, tc.want, got)
+		}
+		h.Reset()
+		for i := range tc.msg {
+			h.Write(tc.msg[i : i+1])
+		}
+		if got := h.Sum64(); got != tc.want {
+			t.Errorf("SipHash of %v written a byte at a time should be %#x, got: %#x", tc.msg, tc.want, got)
+		}
+	}
+}
+
+func TestNewFilterSipHash(t *testing.T) {
+	f, err := NewFilterSipHash(1<<16, 1, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g, _ := NewFilterSipHash(1<<16, 1, 2)
+	other, _ := NewFilterSipHash(1<<16, 2, 1)
+	id := []byte{27, 28, 29}
+	shouldNotContain(t, "fresh id in a SipHash filter", f, id)
+	shouldContain(t, "seen id in a SipHash filter", f, id)
+
+	same := 0
+	for i := 0; i < 1000; i++ {
+		id := binary.BigEndian.AppendUint32(nil, uint32(i))
+		if f.caculateIndex(id) != g.caculateIndex(id) {
+			t.Fatalf("filters with the same key should index %v the same", id)
+		}
+		if f.caculateIndex(id) == other.caculateIndex(id) {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Errorf("%d of 1000 ids had the same index under different keys", same)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+// Stats holds counts of the operations on a filter since it was created.
+type Stats struct {
+	Inserts      uint64 // ids inserted, including ones already present
+	Hits         uint64 // inserts that found the id already present
+	Evictions    uint64 // inserts that evicted a different id
+	Forgets      uint64 // forgets that removed an id
+	ForgetMisses uint64 // forgets that found their slot empty or holding a different id
+	Limited      uint64 // forgets dropped by WithForgetRateLimit
+}
+
+// Stats returns the filter's counters. Each counter is read atomically, but
+// under concurrent use they may not all be from the same instant.
+func (f *Filter) Stats() Stats {
+	return Stats{
+		Inserts:      f.inserts.Load(),
+		Hits:         f.hits.Load(),
+		Evictions:    f.evictions.Load(),
+		Forgets:      f.forgets.Load(),
+		ForgetMisses: f.misses.Load(),
+		Limited:      f.limited.Load(),
+	}
+}
+
+// Inserts returns the Inserts counter of Stats with a single atomic read, as
+// for a monitoring counter. The other counters have a method each likewise.
+func (f *Filter) Inserts() uint64 {
+	return f.inserts.Load()
+}
+
+// Hits returns the Hits counter of Stats.
+func (f *Filter) Hits() uint64 {
+	return f.hits.Load()
+}
+
+// Evictions returns the Evictions counter of Stats.
+func (f *Filter) Evictions() uint64 {
+	return f.evictions.Load()
+}
+
+// Forgets returns the Forgets counter of Stats.
+func (f *Filter) Forgets() uint64 {
+	return f.forgets.Load()
+}
+
+// ForgetMisses returns the ForgetMisses counter of Stats. Forgetting an id
+// that is not there is harmless, but a count much higher than Evictions
+// suggests the caller forgets ids it never added, or forgets them twice.
+func (f *Filter) ForgetMisses() uint64 {
+	return f.misses.Load()
+}
+
+// Limited returns the Limited counter of Stats.
+func (f *Filter) Limited() uint64 {
+	return f.limited.Load()
+}
+
+// MaxCASRetries returns the most compare-and-swaps that an insert into one
+// slot failed before succeeding or giving up, since the filter was created.
+// Each failure means another goroutine changed the slot meanwhile, so a high
+// value points to a hot slot, from a popular id or a bad hash. Inserts that
+// need no retry do not touch the maximum.
+func (f *Filter) MaxCASRetries() uint32 {
+	return f.retries.Load()
+}
+
+// noteRetries raises the MaxCASRetries of f to failed.
+func (f *Filter) noteRetries(failed int) {
+	if failed == 0 {
+		return
+	}
+	for {
+		max := f.retries.Load()
+		if uint32(failed) <= max || f.retries.CompareAndSwap(max, uint32(failed)) {
+			return
+		}
+	}
+}
+
+// NewFilterWithCollisionStats is NewFilter but also counts, for each slot, the
+// inserts that evicted a different id from it, as returned by
+// BucketCollisions. The counts take 4 bytes per slot and start over when the
+// filter grows.
+func NewFilterWithCollisionStats(size int, opts ...Option) (*Filter, error) {
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	f.collisions = true
+	f.table.Store(f.newTable(f.Size()))
+	return f, nil
+}
+
+// BucketCollisions returns a copy of the per-slot eviction counts of a filter
+// made with NewFilterWithCollisionStats, indexed like the slots, or nil for any
+// other filter. Evenly spread counts suggest a good hash, while a few hot slots
+// suggest a bad one or ids crafted to collide.
+func (f *Filter) BucketCollisions() []uint32 {
+	t := f.table.Load()
+	if t.collisions == nil {
+		return nil
+	}
+	counts := make([]uint32, len(t.collisions))
+	for i := range t.collisions {
+		counts[i] = t.collisions[i].Load()
+	}
+	return counts
+}
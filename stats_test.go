@@ -0,0 +1,170 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	f, _ := NewFilter(1)
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	f.Contains(first)         // insert
+	f.Contains(first)         // insert, hit
+	f.ContainsString("other") // insert, eviction
+	f.Add(second)             // insert, eviction
+	f.Forget(first)           // not present
+	f.Forget(second)          // forget
+	f.Contains(second)        // insert
+	want := Stats{Inserts: 5, Hits: 1, Evictions: 2, Forgets: 1, ForgetMisses: 1}
+	if got := f.Stats(); got != want {
+		t.Errorf("stats should be %+v, got: %+v", want, got)
+	}
+	if got := f.Clone().Stats(); got != (Stats{}) {
+		t.Errorf("clone should start with zero stats, got: %+v", got)
+	}
+}
+
+func TestCounterMethods(t *testing.T) {
+	f, _ := NewFilter(1, WithForgetRateLimit(1), WithClock((&fakeClock{t: time.Unix(1000, 0)}).now))
+	first := []byte{27, 28, 29}
+	second := []byte{27, 28, 30}
+	f.Contains(first)  // insert
+	f.Contains(first)  // insert, hit
+	f.Contains(second) // insert, eviction
+	f.Forget(second)   // forget
+	f.Contains(first)  // insert
+	f.Forget(first)    // limited
+	for _, c := range []struct {
+		name      string
+		got, want uint64
+	}{
+		{"Inserts", f.Inserts(), 4},
+		{"Hits", f.Hits(), 1},
+		{"Evictions", f.Evictions(), 1},
+		{"Forgets", f.Forgets(), 1},
+		{"Limited", f.Limited(), 1},
+	} {
+		if c.got != c.want {
+			t.Errorf("%s should be %d, got: %d", c.name, c.want, c.got)
+		}
+	}
+}
+
+func TestForgetMisses(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	for i := 0; i < 100; i++ {
+		f.Contains(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	// Ids from 100 on were never added, and 90 to 99 are forgotten twice.
+	for i := 0; i < 200; i++ {
+		f.Forget(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	for i := 90; i < 100; i++ {
+		f.ForgetString(string(binary.BigEndian.AppendUint32(nil, uint32(i))))
+	}
+	if f.Forgets() != 100 || f.ForgetMisses() != 110 {
+		t.Errorf("forgets should be 100 and misses 110, got %d and %d", f.Forgets(), f.ForgetMisses())
+	}
+}
+
+func TestBucketCollisions(t *testing.T) {
+	f, err := NewFilterWithCollisionStats(1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Find ids crafted to share the first one's slot.
+	hot := f.caculateIndex([]byte{0, 0})
+	var colliding [][]byte
+	for i := 0; len(colliding) < 4; i++ {
+		id := binary.BigEndian.AppendUint16(nil, uint16(i))
+		if f.caculateIndex(id) == hot {
+			colliding = append(colliding, id)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		f.Add(colliding[i%len(colliding)])
+	}
+	f.Add([]byte{9, 9, 9})
+	counts := f.BucketCollisions()
+	if len(counts) != f.Size() {
+		t.Fatalf("should have a count per slot, got: %d", len(counts))
+	}
+	total := uint32(0)
+	for _, c := range counts {
+		total += c
+	}
+	if counts[hot] != 99 || total != 99 {
+		t.Errorf("slot %d should have all 99 collisions, has %d of %d", hot, counts[hot], total)
+	}
+	counts[hot] = 0
+	if f.BucketCollisions()[hot] != 99 {
+		t.Errorf("BucketCollisions should return a copy")
+	}
+	if err := f.Grow(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(f.BucketCollisions()) != f.Size() {
+		t.Errorf("grown filter should still count collisions")
+	}
+	resized, _ := f.Resize(4096)
+	shrunk, _ := f.Shrink(512)
+	union, _ := Union(f, f)
+	intersection, _ := Intersect(f, f)
+	for _, g := range []*Filter{resized, shrunk, union, intersection} {
+		if len(g.BucketCollisions()) != g.Size() {
+			t.Errorf("filter derived from f should count collisions too")
+		}
+	}
+
+	g, _ := NewFilter(1024)
+	if g.BucketCollisions() != nil {
+		t.Errorf("filter without collision stats should return nil")
+	}
+}
+
+func TestMaxCASRetries(t *testing.T) {
+	f, _ := NewFilter(1)
+	if f.MaxCASRetries() != 0 {
+		t.Errorf("fresh filter should have no retries, got: %d", f.MaxCASRetries())
+	}
+
+	// An insert retries if the slot changes between its load and its
+	// compare-and-swap. giveUp is called between the two, so it can do that.
+	tbl := f.table.Load()
+	f.tryStore(tbl, 0, []byte("a"), func(failed int) bool {
+		if failed < 3 {
+			tbl.slots[0].Store(&[]byte{byte(failed)})
+		}
+		return false
+	})
+	if f.MaxCASRetries() != 3 {
+		t.Errorf("insert should have retried 3 times, got: %d", f.MaxCASRetries())
+	}
+
+	if runtime.NumCPU() == 1 || runtime.GOMAXPROCS(0) == 1 {
+		t.Skip("inserts rarely contend on a single CPU")
+	}
+	// Every id goes to the filter's one slot, so concurrent inserts contend.
+	f, _ = NewFilter(1)
+	var wg sync.WaitGroup
+	for g := 0; g < 4*runtime.GOMAXPROCS(0); g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20000 && f.MaxCASRetries() == 0; i++ {
+				f.Contains([]byte{byte(g), byte(i)})
+			}
+		}(g)
+	}
+	wg.Wait()
+	if f.MaxCASRetries() == 0 {
+		t.Errorf("contended inserts should have retried")
+	}
+}
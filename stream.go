@@ -0,0 +1,102 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"bufio"
+	"hash"
+	"io"
+)
+
+// A StreamProbe looks up an id written to it in pieces, so that large ids
+// never have to be held in memory in one piece. Rather than the id itself, the
+// filter stores the digest of the id made by the filter's hash (16 bytes for
+// the default MD5), at the slot Contains would put the digest in, so that it
+// keeps its place when the filter grows or is resized. Ids with the same
+// digest are thus the same id to the filter, and probed ids only match other
+// probed ids, not the ids themselves passed to Contains.
+type StreamProbe struct {
+	f *Filter
+	h hash.Hash32
+}
+
+// ContainsStream returns a StreamProbe for an id to be written to it.
+func (f *Filter) ContainsStream() *StreamProbe {
+	h := f.hashes.Get().(hash.Hash32)
+	h.Reset()
+	f.writeSeed(h)
+	return &StreamProbe{f, h}
+}
+
+// Write adds p to the id. It never returns an error.
+func (s *StreamProbe) Write(p []byte) (int, error) {
+	return s.h.Write(p)
+}
+
+// Done adds the id's digest to the filter and returns true if it was already
+// there, like Contains. The probe must not be used after Done.
+func (s *StreamProbe) Done() bool {
+	digest := s.h.Sum(nil)
+	index := s.f.reduce(s.f.storedSum(s.h, digest))
+	s.f.hashes.Put(s.h)
+	s.h = nil
+	return s.f.containsAt(index, digest)
+}
+
+// ContainsReader reads r to the end and calls Contains with everything read as
+// a single id, which it stores in full. Unlike a StreamProbe it matches ids
+// passed to Contains. If reading fails it returns the error without changing
+// the filter.
+func (f *Filter) ContainsReader(r io.Reader) (bool, error) {
+	id, err := io.ReadAll(r)
+	if err != nil {
+		return false, err
+	}
+	return f.Contains(id), nil
+}
+
+// A DedupScanner reads records from a reader like a bufio.Scanner, but skips
+// the records its filter already holds, such as repeated log lines. Each
+// record it yields is added to the filter. As the filter is lossy, a record
+// evicted from it by a colliding one is yielded again when it comes back.
+type DedupScanner struct {
+	f *Filter
+	s *bufio.Scanner
+}
+
+// DedupScanner returns a DedupScanner reading from r, which splits it into
+// records with split, or into lines if split is nil. Records are copied into
+// the filter, so the scanner may reuse its buffer.
+func (f *Filter) DedupScanner(r io.Reader, split bufio.SplitFunc) *DedupScanner {
+	s := bufio.NewScanner(r)
+	if split != nil {
+		s.Split(split)
+	}
+	return &DedupScanner{f, s}
+}
+
+// Scan advances to the next record not already in the filter, adding it,
+// which is then available through Bytes. It returns false at the end of the
+// input or on an error, as bufio.Scanner.Scan does.
+func (d *DedupScanner) Scan() bool {
+	for d.s.Scan() {
+		if !d.f.ContainsCopy(d.s.Bytes()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Bytes returns the record found by the last call to Scan. Like with
+// bufio.Scanner, it may be overwritten by the next call.
+func (d *DedupScanner) Bytes() []byte {
+	return d.s.Bytes()
+}
+
+// Err returns the first error other than io.EOF met by the scanner, as
+// bufio.Scanner.Err does.
+func (d *DedupScanner) Err() error {
+	return d.s.Err()
+}
@@ -0,0 +1,139 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"bufio"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func probe(f *Filter, pieces ...string) bool {
+	p := f.ContainsStream()
+	for _, piece := range pieces {
+		fmt.Fprint(p, piece)
+	}
+	return p.Done()
+}
+
+func TestContainsStream(t *testing.T) {
+	f, _ := NewFilter(1024)
+	if probe(f, "a large ", "id in ", "pieces") {
+		t.Errorf("fresh streamed id should not be contained")
+	}
+	if !probe(f, "a large id ", "in pieces") {
+		t.Errorf("streamed id should be contained however it is split")
+	}
+	if probe(f, "another id") {
+		t.Errorf("different streamed id should not be contained")
+	}
+	if f.Contains([]byte("a large id in pieces")) {
+		t.Errorf("streamed ids should not match ids passed to Contains")
+	}
+}
+
+func TestContainsStreamStoresDigest(t *testing.T) {
+	f, _ := NewFilter(1)
+	probe(f, "a large ", "id")
+	want := md5.Sum([]byte("a large id"))
+	if got := *f.slot(0); string(got) != string(want[:]) {
+		t.Errorf("stream probe should store the MD5 digest %x, stored: %x", want, got)
+	}
+}
+
+func TestContainsStreamGrow(t *testing.T) {
+	f, _ := NewFilter(1<<10, WithIndexBits(16))
+	ids := make([]string, 100)
+	for i := range ids {
+		ids[i] = fmt.Sprint("streamed id ", i)
+		probe(f, ids[i])
+	}
+	// The digests should be stored where Contains would put them, before and
+	// after the filter grows.
+	for _, grown := range []bool{false, true} {
+		if grown {
+			if err := f.Grow(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+		found := 0
+		for _, id := range ids {
+			if digest := md5.Sum([]byte(id)); f.Peek(digest[:]) {
+				found++
+			}
+		}
+		if found != f.Count() || found < len(ids)-10 {
+			t.Errorf("every stored digest should be found by Peek, found %d of %d (grown: %v)", found, f.Count(), grown)
+		}
+	}
+	if !probe(f, ids[len(ids)-1]) {
+		t.Errorf("streamed id should be contained after growth")
+	}
+}
+
+func TestContainsReader(t *testing.T) {
+	f, _ := NewFilter(1024)
+	g, _ := NewFilter(1024)
+	for _, s := range []string{"line one\n", "line one\n", "", "line two\n"} {
+		present, err := f.ContainsReader(strings.NewReader(s))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := g.Contains([]byte(s)); present != want {
+			t.Errorf("ContainsReader(%q) should be %v like Contains, got: %v", s, want, present)
+		}
+	}
+	if !f.Peek([]byte("line two\n")) {
+		t.Errorf("read id should match ids passed to Contains")
+	}
+
+	fail := errors.New("read failed")
+	r := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(fail))
+	if present, err := f.ContainsReader(r); err != fail || present {
+		t.Errorf("reader error should be returned, got: %v, %v", present, err)
+	}
+	if f.Peek([]byte("partial")) {
+		t.Errorf("failed read should not add anything")
+	}
+}
+
+func TestDedupScanner(t *testing.T) {
+	f, _ := NewFilter(1024)
+	f.Contains([]byte("seen before"))
+	input := "a\nb\na\nseen before\nc\nb\nc\nd\n"
+	d := f.DedupScanner(strings.NewReader(input), nil)
+	var got []string
+	for d.Scan() {
+		got = append(got, string(d.Bytes()))
+	}
+	if err := d.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := "a b c d"; strings.Join(got, " ") != want {
+		t.Errorf("scanner should yield the first occurrence of each record, %q, got: %q", want, got)
+	}
+	if !f.Peek([]byte("d")) {
+		t.Errorf("yielded records should be added to the filter")
+	}
+
+	d = f.DedupScanner(strings.NewReader("b e  e f"), bufio.ScanWords)
+	got = got[:0]
+	for d.Scan() {
+		got = append(got, string(d.Bytes()))
+	}
+	if want := "e f"; strings.Join(got, " ") != want {
+		t.Errorf("scanner should split with the given func and skip records in the filter, %q, got: %q", want, got)
+	}
+
+	fail := errors.New("read failed")
+	d = f.DedupScanner(io.MultiReader(strings.NewReader("g\n"), iotest.ErrReader(fail)), nil)
+	if !d.Scan() || string(d.Bytes()) != "g" || d.Scan() || d.Err() != fail {
+		t.Errorf("scanner should yield records read before an error and then return it, got: %v", d.Err())
+	}
+}
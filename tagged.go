@@ -0,0 +1,100 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"bytes"
+	"hash"
+	"sync"
+	"sync/atomic"
+)
+
+// A TaggedFilter is a Filter that stores a tag with each id, such as the
+// source that first sent it, so that duplicates can be attributed to the
+// original. The tag stays with its id until the id is evicted, by a different
+// id that hashes to its slot, or forgotten.
+//
+// Ids are stored as they are passed in, so they must not be changed
+// afterwards.
+type TaggedFilter struct {
+	slots  []atomic.Pointer[taggedID]
+	mask   uint64
+	hashes *sync.Pool
+}
+
+// taggedID is an id held by a TaggedFilter and the tag it was added with.
+type taggedID struct {
+	id  []byte
+	tag uint32
+}
+
+// NewTaggedFilter returns a TaggedFilter of at least size slots, like
+// NewFilter, which it indexes with MD5.
+func NewTaggedFilter(size int) (*TaggedFilter, error) {
+	if size <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	size = RoundedSize(size)
+	if size == 0 || size > maxFilterSize {
+		return nil, ErrSizeTooLarge
+	}
+	return &TaggedFilter{
+		slots:  make([]atomic.Pointer[taggedID], size),
+		mask:   uint64(size - 1),
+		hashes: newHashPool(newMD5UintHash),
+	}, nil
+}
+
+// ContainsTagged adds id with tag to the filter, unless it is already in it,
+// and returns whether it was. An id already present keeps the tag it was
+// added with, which is returned as existingTag, and tag is dropped. For an id
+// that was not present existingTag is 0.
+func (t *TaggedFilter) ContainsTagged(id []byte, tag uint32) (present bool, existingTag uint32) {
+	slot := &t.slots[t.index(id)]
+	fresh := &taggedID{id: id, tag: tag}
+	for {
+		old := slot.Load()
+		if old != nil && bytes.Equal(old.id, id) {
+			return true, old.tag
+		}
+		if slot.CompareAndSwap(old, fresh) {
+			return false, 0
+		}
+	}
+}
+
+// Tag returns the tag id was added with and true if id is in the filter,
+// without adding it.
+func (t *TaggedFilter) Tag(id []byte) (tag uint32, ok bool) {
+	if e := t.slots[t.index(id)].Load(); e != nil && bytes.Equal(e.id, id) {
+		return e.tag, true
+	}
+	return 0, false
+}
+
+// Forget removes id and its tag if it is in the filter.
+func (t *TaggedFilter) Forget(id []byte) {
+	slot := &t.slots[t.index(id)]
+	for {
+		old := slot.Load()
+		if old == nil || !bytes.Equal(old.id, id) || slot.CompareAndSwap(old, nil) {
+			return
+		}
+	}
+}
+
+// Size returns the number of slots in the filter.
+func (t *TaggedFilter) Size() int {
+	return len(t.slots)
+}
+
+func (t *TaggedFilter) index(id []byte) uint64 {
+	h := t.hashes.Get().(hash.Hash32)
+	h.Reset()
+	h.Write(id)
+	index := sum64(h) & t.mask
+	t.hashes.Put(h)
+	return index
+}
@@ -0,0 +1,56 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import "testing"
+
+func TestTaggedFilter(t *testing.T) {
+	f, err := NewTaggedFilter(1024)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	const sourceA, sourceB = 1, 2
+	id, other := []byte("event 1"), []byte("event 2")
+	if present, tag := f.ContainsTagged(id, sourceA); present || tag != 0 {
+		t.Errorf("fresh id should not be contained, got: %v, %d", present, tag)
+	}
+	if present, tag := f.ContainsTagged(id, sourceB); !present || tag != sourceA {
+		t.Errorf("duplicate should report the tag of the first insert %d, got: %v, %d", sourceA, present, tag)
+	}
+	if tag, ok := f.Tag(id); !ok || tag != sourceA {
+		t.Errorf("id should keep its first tag, got: %d, %v", tag, ok)
+	}
+	if present, _ := f.ContainsTagged(other, sourceB); present {
+		t.Errorf("other id should not be contained")
+	}
+	f.Forget(id)
+	if _, ok := f.Tag(id); ok {
+		t.Errorf("forgotten id should not be contained")
+	}
+	if tag, ok := f.Tag(other); !ok || tag != sourceB {
+		t.Errorf("forgetting an id should leave the others, got: %d, %v", tag, ok)
+	}
+	if f.Size() != 1024 {
+		t.Errorf("filter should have 1024 slots, has: %d", f.Size())
+	}
+	if f, err := NewTaggedFilter(0); err != ErrSizeTooSmall || f != nil {
+		t.Errorf("did not error out on a zero size")
+	}
+}
+
+func TestTaggedFilterEviction(t *testing.T) {
+	// All ids hash to the one slot of the filter.
+	f, _ := NewTaggedFilter(1)
+	a, b := []byte("a"), []byte("b")
+	f.ContainsTagged(a, 1)
+	if present, _ := f.ContainsTagged(b, 2); present {
+		t.Errorf("colliding id should not be contained")
+	}
+	if present, tag := f.ContainsTagged(a, 3); present || tag != 0 {
+		t.Errorf("evicted id should have lost its tag, got: %v, %d", present, tag)
+	}
+	if tag, _ := f.Tag(a); tag != 3 {
+		t.Errorf("id added again should take its new tag, got: %d", tag)
+	}
+}
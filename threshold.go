@@ -0,0 +1,36 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "sync/atomic"
+
+// loadThresholds are the load factors a filter made with WithLoadThresholds
+// notifies of crossing, each with whether it was crossed since the load was
+// last seen below it.
+type loadThresholds struct {
+	levels  []float64
+	crossed []atomic.Bool
+	notify  func(crossed float64)
+}
+
+// checkLoad notifies of the thresholds of f that occupied slots cross
+// upwards and rearms the ones they are below.
+func (f *Filter) checkLoad(occupied int64) {
+	l := f.thresholds
+	if l == nil {
+		return
+	}
+	load := float64(occupied) / float64(f.Size())
+	for i, level := range l.levels {
+		crossed := &l.crossed[i]
+		if load < level {
+			if crossed.Load() {
+				crossed.Store(false)
+			}
+		} else if !crossed.Load() && crossed.CompareAndSwap(false, true) {
+			l.notify(level)
+		}
+	}
+}
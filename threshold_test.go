@@ -0,0 +1,52 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLoadThresholds(t *testing.T) {
+	var crossed []float64
+	notify := func(level float64) { crossed = append(crossed, level) }
+	// Ids go to their own slots of a filter sized exactly for them.
+	f, _ := NewFilterForTest(4, func(id []byte) int { return int(id[0]) },
+		WithLoadThresholds([]float64{0.5, 0.75}, notify))
+	f.Contains([]byte{0})
+	if len(crossed) != 0 {
+		t.Fatalf("load of 0.25 should cross no threshold, crossed: %v", crossed)
+	}
+	f.Contains([]byte{1})
+	f.Contains([]byte{1})
+	f.Contains([]byte{2})
+	f.Contains([]byte{2})
+	if fmt.Sprint(crossed) != "[0.5 0.75]" {
+		t.Fatalf("each threshold should be crossed once, crossed: %v", crossed)
+	}
+
+	// Staying above a threshold does not cross it again, dropping below it
+	// and back does.
+	f.Forget([]byte{2})
+	f.Contains([]byte{2})
+	f.Contains([]byte{3})
+	if fmt.Sprint(crossed) != "[0.5 0.75 0.75]" {
+		t.Fatalf("0.75 should be crossed again after dropping below it, crossed: %v", crossed)
+	}
+	f.Reset()
+	for i := byte(0); i < 4; i++ {
+		f.Contains([]byte{i})
+	}
+	if fmt.Sprint(crossed) != "[0.5 0.75 0.75 0.5 0.75]" {
+		t.Errorf("every threshold should be crossed again after Reset, crossed: %v", crossed)
+	}
+
+	if _, err := NewFilter(4, WithLoadThresholds([]float64{0.5, 1.5}, notify)); err != ErrInvalidLoadFactor {
+		t.Errorf("threshold above 1 should fail with ErrInvalidLoadFactor, got: %v", err)
+	}
+	if _, err := NewFilter(4, WithLoadThresholds([]float64{0.5}, nil)); err != ErrNilNotify {
+		t.Errorf("nil notify should fail with ErrNilNotify, got: %v", err)
+	}
+}
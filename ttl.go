@@ -0,0 +1,105 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrInvalidTTL = errors.New("oppobloom: ttl must be positive")
+var ErrGrowUnsupported = errors.New("oppobloom: filter keeps per-slot state and cannot grow")
+
+// A TTLFilter is a Filter whose ids are treated as new again once they were
+// last seen more than its ttl ago.
+type TTLFilter struct {
+	filter *Filter
+	stamps []atomic.Int64 // when the id in each slot was last seen, in Unix nanoseconds
+	ttl    int64
+}
+
+// NewTTLFilter returns a TTLFilter of at least size slots whose ids expire
+// ttl after they were last seen, as told by the filter's clock. The times are
+// kept per slot, so it returns ErrGrowUnsupported if opts include
+// WithAutoGrow.
+func NewTTLFilter(size int, ttl time.Duration, opts ...Option) (*TTLFilter, error) {
+	if ttl <= 0 {
+		return nil, ErrInvalidTTL
+	}
+	f, err := NewFilter(size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if f.autoGrow > 0 {
+		return nil, ErrGrowUnsupported
+	}
+	return &TTLFilter{f, make([]atomic.Int64, f.Size()), int64(ttl)}, nil
+}
+
+// Contains adds id to the filter and then returns true if id already existed
+// and was last seen no more than the ttl ago. Either way, id is now seen.
+//
+// A slot's id and time are updated one after the other, so a call racing with
+// another on the same slot can see the id of one and the time of the other.
+func (t *TTLFilter) Contains(id []byte) bool {
+	id = t.filter.key(id)
+	index := t.filter.caculateIndex(id)
+	now := t.filter.now().UnixNano()
+	present, _ := t.filter.insert(index, id)
+	seen := t.stamps[index].Swap(now)
+	return present && now-seen <= t.ttl
+}
+
+// Forget removes id if it is in the filter.
+func (t *TTLFilter) Forget(id []byte) {
+	t.filter.Forget(id)
+}
+
+// StartSweeper starts a goroutine that empties the slots of expired ids every
+// interval, as told by the filter's clock, so that ids in slots that are never
+// probed again don't linger. It is safe to use with Contains and Forget: a slot
+// is only emptied if it still holds the id found expired. The returned stop
+// ends the goroutine, waiting for a running sweep, and may be called more than
+// once. interval must be positive, as for time.NewTicker.
+func (t *TTLFilter) StartSweeper(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.sweep()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+		<-stopped
+	}
+}
+
+// sweep empties the slots of the ids last seen more than the ttl ago.
+func (t *TTLFilter) sweep() {
+	now := t.filter.now().UnixNano()
+	slots := t.filter.table.Load()
+	for i := range t.stamps {
+		p := slots.slot(i)
+		if p != nil && now-t.stamps[i].Load() > t.ttl && slots.slots[i].CompareAndSwap(p, forgeted) {
+			t.filter.occupied.Add(-1)
+		}
+	}
+}
+
+// Size returns the number of slots in the filter.
+func (t *TTLFilter) Size() int {
+	return t.filter.Size()
+}
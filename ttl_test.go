@@ -0,0 +1,98 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for tests that only moves when advanced.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestTTLFilter(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	f, err := NewTTLFilter(1024, time.Minute, WithClock(clock.now))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	id := []byte{27, 28, 29}
+	if f.Contains(id) {
+		t.Errorf("fresh id should not be contained")
+	}
+	clock.advance(59 * time.Second)
+	if !f.Contains(id) {
+		t.Errorf("id seen within the ttl should be contained")
+	}
+	clock.advance(59 * time.Second)
+	if !f.Contains(id) {
+		t.Errorf("seeing an id should restart its ttl")
+	}
+	clock.advance(61 * time.Second)
+	if f.Contains(id) {
+		t.Errorf("id seen longer than the ttl ago should be new again")
+	}
+	if !f.Contains(id) {
+		t.Errorf("expired id should be seen again")
+	}
+	f.Forget(id)
+	if f.Contains(id) {
+		t.Errorf("forgotten id should not be contained")
+	}
+}
+
+func TestTTLSweeper(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	f, _ := NewTTLFilter(1024, time.Minute, WithClock(clock.now))
+	old, fresh := []byte{27, 28, 29}, []byte{27, 28, 30}
+	f.Contains(old)
+	stop := f.StartSweeper(time.Millisecond)
+	defer stop()
+	clock.advance(30 * time.Second)
+	f.Contains(fresh)
+	clock.advance(31 * time.Second)
+	for deadline := time.Now().Add(5 * time.Second); f.filter.Count() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("sweeper should empty the expired id's slot, count: %d", f.filter.Count())
+		}
+	}
+	if !f.filter.Peek(fresh) || f.filter.Peek(old) {
+		t.Errorf("sweeper should only empty the expired id's slot")
+	}
+	if n := f.filter.occupied.Load(); n != 1 {
+		t.Errorf("occupied should be 1 after the sweep, got: %d", n)
+	}
+	stop()
+	stop()
+}
+
+func TestInvalidTTL(t *testing.T) {
+	f, err := NewTTLFilter(1024, 0)
+	if err != ErrInvalidTTL || f != nil {
+		t.Errorf("did not error out on a zero ttl")
+	}
+}
+
+func TestTTLFilterAutoGrow(t *testing.T) {
+	f, err := NewTTLFilter(16, time.Hour, WithAutoGrow(0.5))
+	if err != ErrGrowUnsupported || f != nil {
+		t.Errorf("did not error out on WithAutoGrow, got: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "bytes"
+
+// A TwoWayFilter is a Filter whose ids hash to a bucket of two slots rather
+// than to a single slot, so that an id is only lost once two newer ids that
+// hash to its bucket came after it, rather than one. That takes twice the
+// memory of a Filter with a slot per bucket, and roughly halves the rate of
+// false negatives. As with Filter, an id is never reported as contained unless
+// it was added.
+//
+// Each bucket keeps its ids in order of use: a new id goes in the first slot,
+// moving the id there to the second, and an id found in the second slot moves
+// back to the first. Under concurrent use a move racing with another change to
+// the bucket can drop an id, or leave one in both slots.
+//
+// Contains honours the options of its filter like Filter.Contains, counting
+// an id pushed out of its bucket as evicted, for the stats, OnEvict and
+// WithEvictionLog. WithAutoGrow rehashes each slot on its own, so ids of a
+// bucket that land in the same slot of the grown filter are lost.
+type TwoWayFilter struct {
+	filter *Filter
+}
+
+// NewFilter2Way returns a TwoWayFilter of at least size buckets, like
+// NewFilter, and so twice as many slots.
+func NewFilter2Way(size int, opts ...Option) (*TwoWayFilter, error) {
+	if size > maxFilterSize/2 {
+		return nil, ErrSizeTooLarge
+	}
+	if size <= 0 {
+		return nil, ErrSizeTooSmall
+	}
+	f, err := NewFilter(2*size, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TwoWayFilter{f}, nil
+}
+
+// Contains adds id to the filter and returns true if id was already in its
+// bucket.
+func (w *TwoWayFilter) Contains(id []byte) bool {
+	f := w.filter
+	if f.observer != nil {
+		defer f.observe("contains", f.now())
+	}
+	id = f.key(id)
+	if f.rejectEmpty && len(id) == 0 {
+		return false
+	}
+	if f.skipped() {
+		return w.peekKey(id)
+	}
+	index := f.caculateIndex(id)
+	var stored []byte
+	for {
+		t := w.filter.table.Load()
+		i := t.reduce(index) &^ 1
+		first, second := t.slots[i].Load(), t.slots[i+1].Load()
+		switch {
+		case holds(first, id):
+			w.hit()
+			return true
+		case holds(second, id):
+			// Swap the two, the first slot first, so that a lost race
+			// leaves id in both slots rather than in neither.
+			if t.slots[i].CompareAndSwap(first, second) {
+				t.slots[i+1].CompareAndSwap(second, first)
+			}
+			w.hit()
+			return true
+		}
+		if stored == nil {
+			stored = f.storable(id)
+		}
+		if !t.slots[i].CompareAndSwap(first, &stored) {
+			continue
+		}
+		// The id pushed out of the bucket is second, or first if a racing
+		// change to the second slot kept first from moving there.
+		switch {
+		case first == nil || first == forgeted:
+			w.filter.inserted(t, i, stored, nil, false)
+		case !t.slots[i+1].CompareAndSwap(second, first):
+			w.filter.inserted(t, i, stored, *first, true)
+		case second == nil || second == forgeted:
+			w.filter.inserted(t, i+1, stored, nil, false)
+		default:
+			w.filter.inserted(t, i+1, stored, *second, true)
+		}
+		return false
+	}
+}
+
+// hit counts a Contains that found its id, as Filter.Contains does.
+func (w *TwoWayFilter) hit() {
+	w.filter.inserts.Add(1)
+	w.filter.hits.Add(1)
+}
+
+// Peek returns true if id is in its bucket, without adding it.
+func (w *TwoWayFilter) Peek(id []byte) bool {
+	return w.peekKey(w.filter.key(id))
+}
+
+// peekKey is Peek for id as the filter stores it.
+func (w *TwoWayFilter) peekKey(id []byte) bool {
+	t := w.filter.table.Load()
+	i := t.reduce(w.filter.caculateIndex(id)) &^ 1
+	return holds(t.slots[i].Load(), id) || holds(t.slots[i+1].Load(), id)
+}
+
+// Forget removes id from its bucket if it is there.
+func (w *TwoWayFilter) Forget(id []byte) {
+	id = w.filter.key(id)
+	i := w.filter.caculateIndex(id) &^ 1
+	w.filter.forgetAt(i, id)
+	w.filter.forgetAt(i+1, id)
+}
+
+// Size returns the number of buckets in the filter, half its slots.
+func (w *TwoWayFilter) Size() int {
+	return w.filter.Size() / 2
+}
+
+// Count returns the number of slots holding an id, as Filter.Count does.
+func (w *TwoWayFilter) Count() int {
+	return w.filter.Count()
+}
+
+// holds returns true if p, loaded from a slot, points to id.
+func holds(p *[]byte, id []byte) bool {
+	return p != nil && p != forgeted && bytes.Equal(*p, id)
+}
@@ -0,0 +1,130 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func TestTwoWayFilter(t *testing.T) {
+	// All ids hash to the one bucket of the filter.
+	w, err := NewFilter2Way(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a, b, c := []byte{1}, []byte{2}, []byte{3}
+	if w.Contains(a) || w.Contains(b) {
+		t.Errorf("fresh ids should not be contained")
+	}
+	if !w.Peek(a) || !w.Contains(a) || !w.Contains(b) {
+		t.Errorf("two ids should both fit in a bucket")
+	}
+	// b was used last, so c evicts a.
+	if w.Contains(c) || !w.Peek(b) || w.Peek(a) {
+		t.Errorf("new id should evict the least recently used one")
+	}
+	if w.Count() != 2 || w.Size() != 1 {
+		t.Errorf("filter should hold 2 ids in 1 bucket, holds %d in %d", w.Count(), w.Size())
+	}
+	w.Forget(b)
+	if w.Peek(b) || !w.Peek(c) || w.Count() != 1 {
+		t.Errorf("forgetting an id should only remove it, count: %d", w.Count())
+	}
+	if w.Contains(a) || !w.Contains(c) || w.Count() != 2 {
+		t.Errorf("forgotten slot should be reused, count: %d", w.Count())
+	}
+	if w, err := NewFilter2Way(0); err != ErrSizeTooSmall || w != nil {
+		t.Errorf("did not error out on a zero size")
+	}
+}
+
+func TestTwoWayFalseNegatives(t *testing.T) {
+	const size = 1 << 12
+	one, _ := NewFilter(size)
+	two, _ := NewFilter2Way(size)
+	seen := make(map[uint32]bool)
+	oneMisses, twoMisses := 0, 0
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1<<16; i++ {
+		x := uint32(r.Intn(size))
+		id := binary.BigEndian.AppendUint32(nil, x)
+		inOne, inTwo := one.Contains(id), two.Contains(id)
+		if (inOne || inTwo) && !seen[x] {
+			t.Fatalf("id %d was never added but is reported as contained", x)
+		}
+		if seen[x] && !inOne {
+			oneMisses++
+		}
+		if seen[x] && !inTwo {
+			twoMisses++
+		}
+		seen[x] = true
+	}
+	if twoMisses > oneMisses*3/4 {
+		t.Errorf("2-way filter should have far fewer false negatives than a 1-way one, got %d and %d", twoMisses, oneMisses)
+	}
+}
+
+func TestTwoWayConcurrent(t *testing.T) {
+	w, _ := NewFilter2Way(4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := []byte{byte(i % 20)}
+				w.Contains(id)
+				if i%4 == g%4 {
+					w.Forget(id)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	for i := 0; i < 20; i++ {
+		w.Forget([]byte{byte(i)})
+	}
+	if w.Count() != 0 || w.filter.occupied.Load() != 0 {
+		t.Errorf("filter should be empty after forgetting every id, count: %d, occupied: %d", w.Count(), w.filter.occupied.Load())
+	}
+}
+
+func TestTwoWayOptions(t *testing.T) {
+	var evicted []string
+	w, _ := NewFilter2Way(1, WithEvictionLog(4))
+	w.filter.OnEvict(func(old, _ []byte) { evicted = append(evicted, string(old)) })
+	for _, id := range []string{"a", "b", "b", "c"} {
+		w.Contains([]byte(id))
+	}
+	want := Stats{Inserts: 4, Hits: 1, Evictions: 1}
+	if got := w.filter.Stats(); got != want {
+		t.Errorf("stats should be %+v, got: %+v", want, got)
+	}
+	if len(evicted) != 1 || evicted[0] != "a" {
+		t.Errorf("id pushed out of its bucket should be evicted, got: %q", evicted)
+	}
+	if log := w.filter.EvictionLog(); len(log) != 1 || string(log[0].Evicted) != "a" {
+		t.Errorf("eviction should be logged, got: %q", log)
+	}
+
+	g, _ := NewFilter2Way(4, WithAutoGrow(0.5))
+	for i := 0; i < 100; i++ {
+		g.Contains([]byte{byte(i)})
+	}
+	if g.Size() <= 4 {
+		t.Errorf("filter should have grown, size: %d", g.Size())
+	}
+	if g.Count() != int(g.filter.occupied.Load()) {
+		t.Errorf("count %d should match the occupied slots %d", g.Count(), g.filter.occupied.Load())
+	}
+
+	r, _ := NewFilter2Way(4, WithRejectEmpty())
+	if r.Contains(nil) || r.Contains(nil) {
+		t.Errorf("empty id should be rejected")
+	}
+}
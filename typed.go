@@ -0,0 +1,48 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import "errors"
+
+var ErrNilEncoder = errors.New("oppobloom: encoder cannot be nil")
+
+// TypedFilter is a Filter over keys of type T. Keys are turned into ids with
+// the encoder given to NewTypedFilter.
+type TypedFilter[T comparable] struct {
+	filter *Filter
+	encode func(T) []byte
+}
+
+// NewTypedFilter returns a TypedFilter of at least size slots that encodes
+// its keys with encode. Keys that encode to nil are treated as the empty id.
+func NewTypedFilter[T comparable](size int, encode func(T) []byte) (*TypedFilter[T], error) {
+	if encode == nil {
+		return nil, ErrNilEncoder
+	}
+	f, err := NewFilter(size)
+	if err != nil {
+		return nil, err
+	}
+	return &TypedFilter[T]{f, encode}, nil
+}
+
+// Contains adds key to the filter and then returns true if key already
+// existed.
+func (t *TypedFilter[T]) Contains(key T) bool {
+	return t.filter.Contains(t.id(key))
+}
+
+// Forget removes key if it is in the filter.
+func (t *TypedFilter[T]) Forget(key T) {
+	t.filter.Forget(t.id(key))
+}
+
+func (t *TypedFilter[T]) id(key T) []byte {
+	id := t.encode(key)
+	if id == nil {
+		id = []byte{}
+	}
+	return id
+}
@@ -0,0 +1,73 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestTypedFilterString(t *testing.T) {
+	calls := 0
+	f, err := NewTypedFilter(1024, func(s string) []byte {
+		calls++
+		return []byte(s)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if f.Contains("foo") {
+		t.Errorf("foo should not be contained yet")
+	}
+	if !f.Contains("foo") {
+		t.Errorf("foo should be contained")
+	}
+	f.Forget("foo")
+	if f.Contains("foo") {
+		t.Errorf("foo should have been forgotten")
+	}
+	if calls != 4 {
+		t.Errorf("encoder should be called once per operation, called %d times", calls)
+	}
+}
+
+func TestTypedFilterInt64(t *testing.T) {
+	f, _ := NewTypedFilter(1024, func(x int64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, uint64(x))
+		return b
+	})
+	for _, x := range []int64{0, 1, -1, 1 << 40} {
+		if f.Contains(x) {
+			t.Errorf("%d should not be contained yet", x)
+		}
+		if !f.Contains(x) {
+			t.Errorf("%d should be contained", x)
+		}
+	}
+}
+
+func TestTypedFilterNilEncoding(t *testing.T) {
+	f, _ := NewTypedFilter(2, func(x int) []byte { return nil })
+	if f.Contains(1) {
+		t.Errorf("nil encoding should not be contained yet")
+	}
+	if !f.Contains(2) {
+		t.Errorf("nil encodings should all be the empty id")
+	}
+	f.Forget(1)
+	if f.Contains(3) {
+		t.Errorf("nil encoding should have been forgotten")
+	}
+}
+
+func TestTypedFilterNilEncoder(t *testing.T) {
+	f, err := NewTypedFilter[string](2, nil)
+	if err != ErrNilEncoder {
+		t.Errorf("did not error out on a nil encoder")
+	}
+	if f != nil {
+		t.Errorf("did not return nil on a nil encoder")
+	}
+}
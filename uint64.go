@@ -0,0 +1,88 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package oppobloom
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// ContainsUint64 is Contains for an integer id. Instead of hashing x with the
+// filter's hash it mixes x with splitmix64, which is much faster, and stores
+// it as its 8 bytes in big-endian order. Integer ids are not fingerprinted, and
+// their slots are unrelated to those of []byte ids, so a filter should be used
+// with either kind of id but not both. Once ContainsUint64 was called, Grow,
+// Resize and AbsorbFrom mix the 8 byte ids they move with splitmix64 too, as do
+// the filters made from f by Clone and Resize. Snapshots don't record this, so
+// a filter restored from one should not be grown.
+func (f *Filter) ContainsUint64(x uint64) bool {
+	if !f.uint64s.Load() {
+		f.uint64s.Store(true)
+	}
+	return f.containsAt(f.caculateUint64Index(x), binary.BigEndian.AppendUint64(nil, x))
+}
+
+// PeekUint64 is Peek for an integer id added with ContainsUint64.
+func (f *Filter) PeekUint64(x uint64) bool {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], x)
+	return f.peekAt(f.caculateUint64Index(x), id[:])
+}
+
+// ForgetUint64 is Forget for an integer id added with ContainsUint64.
+func (f *Filter) ForgetUint64(x uint64) {
+	var id [8]byte
+	binary.BigEndian.PutUint64(id[:], x)
+	f.forgetAt(f.caculateUint64Index(x), id[:])
+}
+
+func (f *Filter) caculateUint64Index(x uint64) uint64 {
+	return f.reduce(f.uint64Sum(x))
+}
+
+// uint64Sum is caculateUint64Index before masking.
+func (f *Filter) uint64Sum(x uint64) uint64 {
+	if f.seed != nil {
+		x ^= binary.LittleEndian.Uint64(f.seed)
+	}
+	return f.narrow(splitmix64(x))
+}
+
+// storedSum is caculateSum for an id stored in a filter, which mixes 8 byte
+// ids with uint64Sum if the filter holds ids added by ContainsUint64.
+func (f *Filter) storedSum(h hash.Hash32, id []byte) uint64 {
+	if len(id) == 8 && f.uint64s.Load() {
+		return f.uint64Sum(binary.BigEndian.Uint64(id))
+	}
+	if h == nil {
+		return f.caculateHash(id)
+	}
+	return f.caculateSum(h, id)
+}
+
+// maxShortID is the length up to which WithAdaptiveHash mixes ids with
+// shortSum.
+const maxShortID = 8
+
+// shortSum mixes an id of at most maxShortID bytes like caculateUint64Index,
+// with its length so that ids differing only in trailing zeros are spread out.
+func (f *Filter) shortSum(id []byte) uint64 {
+	var b [maxShortID]byte
+	copy(b[:], id)
+	x := binary.LittleEndian.Uint64(b[:])
+	if f.seed != nil {
+		x ^= binary.LittleEndian.Uint64(f.seed)
+	}
+	return splitmix64(splitmix64(x) + uint64(len(id)))
+}
+
+// splitmix64 is the finalizer of the SplitMix64 generator, which spreads every
+// bit of x over all 64 bits of the result.
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
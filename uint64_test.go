@@ -0,0 +1,98 @@
+// Copyright 2012 Jeff Hodges. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+package oppobloom
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestContainsUint64(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	for _, x := range []uint64{0, 1, 1 << 63, ^uint64(0)} {
+		if f.PeekUint64(x) || f.ContainsUint64(x) {
+			t.Errorf("fresh id %d should not be contained", x)
+		}
+		if !f.PeekUint64(x) || !f.ContainsUint64(x) {
+			t.Errorf("seen id %d should be contained", x)
+		}
+		f.ForgetUint64(x)
+		if f.PeekUint64(x) {
+			t.Errorf("forgotten id %d should not be contained", x)
+		}
+	}
+	f.ContainsUint64(42)
+	stored := false
+	f.Range(func(id []byte) bool {
+		stored = len(id) == 8 && binary.BigEndian.Uint64(id) == 42
+		return true
+	})
+	if !stored {
+		t.Errorf("id should be stored as its 8 big-endian bytes")
+	}
+}
+
+func TestUint64Grow(t *testing.T) {
+	f, _ := NewFilter(1 << 10)
+	for x := uint64(0); x < 100; x++ {
+		f.ContainsUint64(x)
+	}
+	if err := f.Grow(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	g, _ := f.Resize(1 << 12)
+	h, _ := NewFilter(1 << 12)
+	h.AbsorbFrom(f)
+	for name, f := range map[string]*Filter{"grown": f, "resized": g, "absorbing": h, "cloned": f.Clone()} {
+		missing := 0
+		for x := uint64(0); x < 100; x++ {
+			if !f.PeekUint64(x) {
+				missing++
+			}
+		}
+		// Some of the ids collided on the way.
+		if missing > 5 {
+			t.Errorf("%s filter should still hold the integer ids, %d of 100 missing", name, missing)
+		}
+	}
+}
+
+func TestUint64Distribution(t *testing.T) {
+	f, _ := NewFilter(1 << 16)
+	// Sequential ids should spread over the whole filter.
+	for x := uint64(0); x < 1000; x++ {
+		f.ContainsUint64(x)
+	}
+	if c := f.Count(); c < 980 {
+		t.Errorf("1000 sequential ids should rarely collide, count: %d", c)
+	}
+
+	a, _ := NewFilterWithSeed(1<<16, 1)
+	b, _ := NewFilterWithSeed(1<<16, 2)
+	same := 0
+	for x := uint64(0); x < 1000; x++ {
+		if a.caculateUint64Index(x) == b.caculateUint64Index(x) {
+			same++
+		}
+	}
+	if same > 5 {
+		t.Errorf("%d of 1000 ids had the same index with different seeds", same)
+	}
+}
+
+func BenchmarkContainsUint64(b *testing.B) {
+	f, _ := NewFilter(1 << 16)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.ContainsUint64(uint64(i))
+	}
+}
+
+func BenchmarkContainsUint64Bytes(b *testing.B) {
+	f, _ := NewFilter(1 << 16)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		f.Contains(binary.BigEndian.AppendUint64(nil, uint64(i)))
+	}
+}